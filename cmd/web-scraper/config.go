@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/STTM-NSU/web-scrapper/internal/archive"
+	"github.com/STTM-NSU/web-scrapper/internal/breaker"
+	"github.com/STTM-NSU/web-scrapper/internal/concurrency"
+	"github.com/STTM-NSU/web-scrapper/internal/config"
+	"github.com/STTM-NSU/web-scrapper/internal/db/redis"
+	"github.com/STTM-NSU/web-scrapper/internal/quality"
+	"github.com/STTM-NSU/web-scrapper/internal/retry"
+	"github.com/STTM-NSU/web-scrapper/internal/ria"
+)
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+func redisConfig(cfg config.Config) redis.Config {
+	var addrs []string
+	if cfg.RedisAddrs != "" {
+		addrs = strings.Split(cfg.RedisAddrs, ",")
+	}
+	return redis.Config{
+		Mode:             cfg.RedisMode,
+		Host:             cfg.RedisHost,
+		Port:             cfg.RedisPort,
+		Addrs:            addrs,
+		MasterName:       cfg.RedisMasterName,
+		SentinelUsername: cfg.RedisSentinelUsername,
+		SentinelPassword: cfg.RedisSentinelPassword,
+		Username:         cfg.RedisUsername,
+		Password:         cfg.RedisPassword,
+		DB:               cfg.RedisDB,
+		TLS: redis.TLSConfig{
+			Enabled:            cfg.RedisTLS,
+			CAFile:             cfg.RedisTLSCAFile,
+			CertFile:           cfg.RedisTLSCertFile,
+			KeyFile:            cfg.RedisTLSKeyFile,
+			ServerName:         cfg.RedisTLSServerName,
+			InsecureSkipVerify: cfg.RedisTLSInsecureSkipVerify,
+		},
+		PoolSize:     cfg.RedisPoolSize,
+		MinIdleConns: cfg.RedisMinIdleConns,
+		PoolTimeout:  seconds(cfg.RedisPoolTimeOut),
+		DialTimeout:  seconds(cfg.RedisDialTimeOut),
+		ReadTimeout:  seconds(cfg.RedisReadTimeOut),
+		WriteTimeout: seconds(cfg.RedisWriteTimeOut),
+		Connect: retry.Policy{
+			MaxAttempts: cfg.RedisConnectAttempts,
+			BaseDelay:   time.Second,
+			MaxDelay:    seconds(cfg.RedisConnectMaxDelay),
+		},
+	}
+}
+
+func archiveConfig(cfg config.Config) archive.Config {
+	return archive.Config{
+		Dir:     cfg.ArchiveDir,
+		Prefix:  cfg.ArchivePrefix,
+		MaxSize: int64(cfg.ArchiveMaxSize) << 20,
+		MaxAge:  seconds(cfg.ArchiveMaxAge),
+	}
+}
+
+func qualityConfig(cfg config.Config) quality.Config {
+	return quality.Config{
+		Window:        seconds(cfg.QualityWindow),
+		CheckInterval: seconds(cfg.QualityCheckInterval),
+		MinArticles:   cfg.QualityMinArticles,
+		Thresholds: map[string]float64{
+			quality.Title: cfg.QualityMinTitle,
+			quality.Body:  cfg.QualityMinBody,
+			quality.Date:  cfg.QualityMinDate,
+		},
+		MinBodyLength:  cfg.QualityMinBodyLength,
+		Samples:        cfg.QualitySamples,
+		Webhook:        cfg.QualityWebhook,
+		WebhookTimeout: 10 * time.Second,
+	}
+}
+
+func concurrencyConfig(cfg config.Config) concurrency.Config {
+	return concurrency.Config{
+		Min:           cfg.MinConcurrency,
+		Max:           cfg.MaxConcurrency,
+		PerProxy:      cfg.ConcurrencyPerProxy,
+		BackfillShare: cfg.BackfillShare,
+	}
+}
+
+func breakerConfig(cfg config.Config) breaker.Config {
+	return breaker.Config{
+		Window:      seconds(cfg.BreakerWindow),
+		MinRequests: cfg.BreakerMinRequests,
+		FailureRate: cfg.BreakerFailureRate,
+		OpenTimeout: seconds(cfg.BreakerOpenTimeout),
+	}
+}
+
+func riaConfig(cfg config.Config) ria.Config {
+	return ria.Config{
+		RedisChanelName:   cfg.RedisChanelName,
+		PartitionsCount:   cfg.PartitionsCount,
+		DefaultRetryAfter: seconds(cfg.DefaultRetryAfter),
+		Retry: retry.Policy{
+			MaxAttempts: cfg.RetryMaxAttempts,
+			BaseDelay:   seconds(cfg.RetryBaseDelay),
+			MaxDelay:    seconds(cfg.RetryMaxDelay),
+		},
+		MaxConcurrency:     cfg.MaxConcurrency,
+		StickySessions:     cfg.StickySessions,
+		DrainTimeOut:       seconds(cfg.DrainTimeOut),
+		ProxyBlameWindow:   seconds(cfg.BreakerWindow),
+		UserAgent:          cfg.UserAgent,
+		RequestDelay:       seconds(cfg.RequestDelay),
+		RequestRandomDelay: seconds(cfg.RequestRandomDelay),
+		RequestTimeOut:     seconds(cfg.RequestTimeOut),
+		CassetteMode:       cfg.CassetteMode,
+		CassetteDir:        cfg.CassetteDir,
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/STTM-NSU/web-scrapper/internal/breaker"
+	"github.com/STTM-NSU/web-scrapper/internal/concurrency"
+	"github.com/STTM-NSU/web-scrapper/internal/config"
+	"github.com/STTM-NSU/web-scrapper/internal/proxy"
+	"github.com/STTM-NSU/web-scrapper/internal/publish"
+	"github.com/STTM-NSU/web-scrapper/internal/ria"
+)
+
+const _dryRunCommand = "dry-run"
+
+// dryRun crawls the day of the source as usual but writes the articles and the day report
+// to the --out file instead of publishing them. Redis is not used: there are no checkpoints,
+// dead letters, archive, fingerprints or revisions, and the proxy usage is not saved.
+func dryRun(ctx context.Context, cfg config.Config, args []string, log *slog.Logger) error {
+	fs := flag.NewFlagSet(_dryRunCommand, flag.ContinueOnError)
+	source := fs.String("source", ria.Source, "source to crawl")
+	dateFlag := fs.String("date", time.Now().Format(time.DateOnly), "day to crawl, 2006-01-02")
+	out := fs.String("out", "-", "JSON lines file to write the messages to, - is stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *source != ria.Source {
+		return fmt.Errorf("unknown source %s", *source)
+	}
+	date, err := time.Parse(time.DateOnly, *dateFlag)
+	if err != nil {
+		return fmt.Errorf("bad date: %w", err)
+	}
+
+	limiter := proxy.NewLimiter(cfg.ProxyRequestsPerSecond, cfg.ProxyBurst)
+	// the usage is counted against the quotas of the run only, it is never flushed
+	accountant := proxy.NewAccountant(nil, cfg.ProxyStatsKey, cfg.ProxyQuotas, log)
+	proxySwitcher, err := proxy.MyRoundRobinProxySwitcher(cfg.Proxies, log, cfg.ProxyRecoverTimeOut, limiter, accountant)
+	if err != nil {
+		return fmt.Errorf("can't get proxy: %w", err)
+	}
+	concurrencyController := concurrency.NewController(concurrencyConfig(cfg), proxySwitcher.Healthy, log)
+	circuitBreaker := breaker.New(breakerConfig(cfg), log)
+
+	file, err := publish.NewFile(*out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	bgCtx, cancelBg := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer func() {
+		cancelBg()
+		wg.Wait()
+	}()
+	for _, run := range []func(context.Context){proxySwitcher.Run, proxySwitcher.RunForRecover, concurrencyController.Run} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run(bgCtx)
+		}()
+	}
+
+	riaScrapper := ria.NewScrapper(file, log, proxySwitcher, nil, concurrencyController, circuitBreaker, accountant, nil, nil, nil, nil, nil, riaConfig(cfg))
+
+	log.Info("start dry run", slog.String("source", *source), slog.String("date", *dateFlag), slog.String("out", *out))
+	return riaScrapper.Scrap(ctx, date.Format("20060102"))
+}
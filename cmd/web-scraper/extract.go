@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/bytedance/sonic"
+
+	"github.com/STTM-NSU/web-scrapper/internal/config"
+	"github.com/STTM-NSU/web-scrapper/internal/ria"
+)
+
+const _extractCommand = "extract"
+
+// extract prints the article extracted from the page of the url as JSON. The page is fetched
+// directly, without the proxies, or read from the --file saved before. Redis is not used.
+func extract(ctx context.Context, cfg config.Config, args []string) error {
+	fs := flag.NewFlagSet(_extractCommand, flag.ContinueOnError)
+	file := fs.String("file", "", "saved HTML page of the url to use instead of fetching it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: extract [--file page.html] <url>")
+	}
+	url := fs.Arg(0)
+
+	var page io.Reader
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return fmt.Errorf("can't open page: %w", err)
+		}
+		defer f.Close()
+		page = f
+	} else {
+		body, err := fetch(ctx, cfg, url)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		page = body
+	}
+
+	res, err := ria.Extract(riaConfig(cfg), url, page)
+	if err != nil {
+		return err
+	}
+	out, err := sonic.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return fmt.Errorf("can't marshal article: %w", err)
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+func fetch(ctx context.Context, cfg config.Config, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't create request: %w", err)
+	}
+	if cfg.UserAgent != "" {
+		req.Header.Set("User-Agent", cfg.UserAgent)
+	}
+	resp, err := (&http.Client{Timeout: seconds(cfg.RequestTimeOut)}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("can't fetch page: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("can't fetch page: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/STTM-NSU/web-scrapper/internal/health"
+	"github.com/STTM-NSU/web-scrapper/internal/proxy"
+	"github.com/STTM-NSU/web-scrapper/internal/runner"
+)
+
+// newChecker sets up the probes. Liveness fails when a runner loop is dead, when a crawl
+// fetched nothing for stallTimeOut, or when requests wait for a proxy for that long,
+// so the pod gets restarted. Waits for the proxy quotas to reset are not stalls, a restart
+// doesn't help them, so the time spent in them is not counted. Readiness needs Redis and
+// at least one healthy proxy with quota left.
+func newChecker(rdb redis.UniversalClient,
+	proxySwitcher *proxy.MyRoundRobinSwitcher,
+	accountant *proxy.Accountant,
+	runners []*runner.Runner,
+	stallTimeOut time.Duration,
+	log *slog.Logger) *health.Checker {
+	// the stall timers start again after the quotas were exhausted
+	var quotaWait atomic.Int64
+	quotaWait.Store(time.Now().UnixNano())
+	sinceQuotaWait := func() time.Duration {
+		if proxySwitcher.QuotaExhausted() {
+			quotaWait.Store(time.Now().UnixNano())
+		}
+		return time.Since(time.Unix(0, quotaWait.Load()))
+	}
+	checker := health.NewChecker(log)
+
+	for _, r := range runners {
+		checker.AddLiveness("runner:"+r.Source(), func(context.Context) error {
+			if !r.Running() {
+				return fmt.Errorf("runner is not running")
+			}
+			return nil
+		})
+	}
+	checker.AddLiveness("fetch", func(context.Context) error {
+		var busy bool
+		for _, r := range runners {
+			busy = busy || r.Busy()
+		}
+		since := min(time.Since(accountant.LastResponse()), sinceQuotaWait())
+		if busy && since > stallTimeOut {
+			return fmt.Errorf("no page fetched for %s", since.Round(time.Second))
+		}
+		return nil
+	})
+	checker.AddLiveness("proxy", func(context.Context) error {
+		if waiting := min(proxySwitcher.WaitingFor(), sinceQuotaWait()); waiting > stallTimeOut {
+			return fmt.Errorf("waiting for proxy for %s", waiting.Round(time.Second))
+		}
+		return nil
+	})
+
+	checker.AddReadiness("redis", func(ctx context.Context) error {
+		return rdb.Ping(ctx).Err()
+	})
+	checker.AddReadiness("proxy", func(context.Context) error {
+		if proxySwitcher.QuotaExhausted() {
+			return fmt.Errorf("proxy quotas exhausted")
+		}
+		if proxySwitcher.Healthy() == 0 {
+			return fmt.Errorf("no healthy proxy")
+		}
+		return nil
+	})
+
+	return checker
+}
@@ -2,108 +2,268 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"log/slog"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/joho/godotenv"
 
+	"github.com/STTM-NSU/web-scrapper/internal/admin"
+	"github.com/STTM-NSU/web-scrapper/internal/archive"
+	"github.com/STTM-NSU/web-scrapper/internal/breaker"
+	"github.com/STTM-NSU/web-scrapper/internal/checkpoint"
+	"github.com/STTM-NSU/web-scrapper/internal/concurrency"
 	"github.com/STTM-NSU/web-scrapper/internal/config"
 	"github.com/STTM-NSU/web-scrapper/internal/db/redis"
-	"github.com/STTM-NSU/web-scrapper/internal/model"
+	"github.com/STTM-NSU/web-scrapper/internal/deadletter"
+	"github.com/STTM-NSU/web-scrapper/internal/dedup"
+	"github.com/STTM-NSU/web-scrapper/internal/lease"
+	"github.com/STTM-NSU/web-scrapper/internal/metrics"
 	"github.com/STTM-NSU/web-scrapper/internal/proxy"
+	"github.com/STTM-NSU/web-scrapper/internal/publish"
+	"github.com/STTM-NSU/web-scrapper/internal/quality"
+	"github.com/STTM-NSU/web-scrapper/internal/revision"
 	"github.com/STTM-NSU/web-scrapper/internal/ria"
+	"github.com/STTM-NSU/web-scrapper/internal/runner"
+	"github.com/STTM-NSU/web-scrapper/internal/schedule"
 )
 
-const _configFileName = "./configs/config.yaml"
-
 func main() {
+	var level slog.LevelVar
 	log := slog.New(
-		slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: &level}),
 	)
 
-	if err := godotenv.Load(); err != nil {
-		log.Error("error loading .env file: " + err.Error())
-	}
+	envErr := godotenv.Load()
 
-	cfgRedis := redis.Config{
-		Host:     os.Getenv(model.EnvRedisHost),
-		Port:     os.Getenv(model.EnvRedisPort),
-		Password: os.Getenv(model.EnvRedisPassword),
+	cfg, args, err := config.Load(os.Args[1:], _extractCommand, _reparseCommand)
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
-	if cfgRedis.Host == "" {
-		log.Error("can't get host from env")
+	if err != nil {
+		log.Error("can't load config: " + err.Error())
 		return
 	}
-	if cfgRedis.Port == "" {
-		log.Error("can't get port from env")
+	if len(args) > 0 && (args[0] == _extractCommand || args[0] == _reparseCommand || args[0] == _dryRunCommand) {
+		// the output of the commands may go to stdout, the logs must not mix with it
+		log = slog.New(
+			slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: &level}),
+		)
+	}
+	if envErr != nil {
+		log.Error("error loading .env file: " + envErr.Error())
+	}
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		log.Error("can't set log level: " + err.Error())
 		return
 	}
+	log.Info("config loaded", slog.Any("config", cfg.Redacted()))
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	rdb, err := redis.Connect(ctx, cfgRedis)
-	if err != nil {
-		log.Error("can't connect to  redis: " + err.Error())
+	if len(args) > 0 && args[0] == _extractCommand {
+		if err := extract(ctx, cfg, args[1:]); err != nil {
+			log.Error("can't extract: " + err.Error())
+		}
+		return
+	}
+	if len(args) > 0 && args[0] == _reparseCommand {
+		if err := reparse(ctx, cfg, args[1:], log); err != nil {
+			log.Error("can't reparse: " + err.Error())
+		}
+		return
+	}
+	if len(args) > 0 && args[0] == _dryRunCommand {
+		if err := dryRun(ctx, cfg, args[1:], log); err != nil {
+			log.Error("can't dry run: " + err.Error())
+		}
 		return
 	}
 
-	log.Info(rdb.String())
-
-	cfg, err := config.LoadConfig(_configFileName)
+	rdb, err := redis.Connect(ctx, redisConfig(cfg), log)
 	if err != nil {
-		log.Error("can't load config: " + err.Error())
+		log.Error("can't connect to  redis: " + err.Error())
 		return
 	}
+	defer rdb.Close()
 
-	proxySwitcher, err := proxy.MyRoundRobinProxySwitcher(os.Getenv(model.EnvProxyUrls), log, cfg.ProxyRecoverTimeOut)
+	limiter := proxy.NewLimiter(cfg.ProxyRequestsPerSecond, cfg.ProxyBurst)
+	accountant := proxy.NewAccountant(rdb, cfg.ProxyStatsKey, cfg.ProxyQuotas, log)
+	proxySwitcher, err := proxy.MyRoundRobinProxySwitcher(cfg.Proxies, log, cfg.ProxyRecoverTimeOut, limiter, accountant)
 	if err != nil {
 		log.Error("can't get proxy: " + err.Error())
 		return
 	}
+	if err := accountant.Load(ctx, proxySwitcher.Hosts()...); err != nil {
+		log.Error("can't load proxy stats: " + err.Error())
+		return
+	}
+
+	deadLetter := deadletter.NewQueue(rdb, cfg.DeadLetterKey)
+	checkpoints := checkpoint.NewStore(rdb, cfg.CheckpointKey)
+
+	concurrencyController := concurrency.NewController(concurrencyConfig(cfg), proxySwitcher.Healthy, log)
+	circuitBreaker := breaker.New(breakerConfig(cfg), log)
+	var archiveWriter *archive.Writer
+	if cfg.ArchiveEnabled {
+		archiveWriter, err = archive.NewWriter(archiveConfig(cfg), log)
+		if err != nil {
+			log.Error("can't create archive: " + err.Error())
+			return
+		}
+		defer func() {
+			if err := archiveWriter.Close(); err != nil {
+				log.Error(err.Error())
+			}
+		}()
+	}
+
+	qualityMonitor := quality.NewMonitor(qualityConfig(cfg), log)
+	var dedupIndex *dedup.Index
+	if cfg.DedupEnabled {
+		dedupIndex = dedup.NewIndex(rdb, dedup.Config{
+			KeyPrefix:   cfg.DedupKey,
+			Window:      seconds(cfg.DedupWindow),
+			MaxDistance: cfg.DedupMaxDistance,
+		})
+	}
+
+	var revisions *revision.Tracker
+	if cfg.RevisionDelays != "" {
+		delays, err := cfg.Revisions()
+		if err != nil {
+			log.Error(err.Error())
+			return
+		}
+		revisions = revision.NewTracker(rdb, cfg.RevisionKey, delays)
+	}
+
+	riaScrapper := ria.NewScrapper(publish.NewRedis(rdb), log, proxySwitcher, deadLetter, concurrencyController, circuitBreaker, accountant, checkpoints, archiveWriter, qualityMonitor, dedupIndex, revisions, riaConfig(cfg))
 
-	riaScrapper := ria.NewScrapper(rdb, log, proxySwitcher, cfg.RedisChanelName, cfg.PartitionsCount)
+	// the infrastructure is stopped only after the runners are drained
+	bgCtx, cancelBg := context.WithCancel(context.Background())
+	defer cancelBg()
 
 	var wg sync.WaitGroup
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		proxySwitcher.Run(ctx)
+		proxySwitcher.Run(bgCtx)
 	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		proxySwitcher.RunForRecover(ctx)
+		proxySwitcher.RunForRecover(bgCtx)
 	}()
 
-	var done bool
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		data := cfg.StartDateScrapping
-		for !done {
+		concurrencyController.Run(bgCtx)
+	}()
 
-			if err := riaScrapper.Scrap(ctx, data.Format("20060102")); err != nil {
-				log.Error("can't scrap ria " + err.Error())
-			}
-			if data.Format("20060102") == time.Now().Format("20060102") {
-				time.Sleep(1 * time.Hour)
-			} else {
-				data = data.Add(24 * time.Hour)
-			}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		accountant.Run(bgCtx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		qualityMonitor.Run(bgCtx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		(&reloader{
+			cfg:         cfg,
+			args:        os.Args[1:],
+			level:       &level,
+			limiter:     limiter,
+			concurrency: concurrencyController,
+			breaker:     circuitBreaker,
+			riaScrapper: riaScrapper,
+			log:         log,
+		}).Run(bgCtx)
+	}()
+
+	if len(args) > 0 && args[0] == _redriveCommand {
+		if err := redrive(ctx, log, deadLetter, riaScrapper); err != nil {
+			log.Error("can't redrive: " + err.Error())
+		}
+		cancelBg()
+		wg.Wait()
+		return
+	}
+
+	metrics.RegisterProxyPool(proxySwitcher.PoolSizes)
+	metrics.RegisterConcurrencyLimit(concurrencyController.Limit)
 
+	leases := lease.NewManager(rdb, cfg.LeaseKey, seconds(cfg.LeaseTTL), log)
+	riaSchedule, err := schedule.New(cfg.Schedule(ria.Source))
+	if err != nil {
+		log.Error("can't create schedule: " + err.Error())
+		return
+	}
+	riaRunner := runner.New(ria.Source, riaScrapper, cfg.StartDateScrapping, checkpoints, leases, riaSchedule, log)
+
+	runners := []*runner.Runner{riaRunner}
+	checker := newChecker(rdb, proxySwitcher, accountant, runners,
+		time.Duration(cfg.StallTimeOut)*time.Second, log)
+
+	adminServer := admin.NewServer(admin.Config{
+		Host:       cfg.AdminHost,
+		Port:       cfg.AdminPort,
+		ProbesHost: cfg.ProbesHost,
+		ProbesPort: cfg.ProbesPort,
+	}, runners, proxySwitcher, accountant, deadLetter, &level, checker, log)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := adminServer.Run(bgCtx); err != nil {
+			log.Error(err.Error())
 		}
 	}()
 
+	var runnersWg sync.WaitGroup
+
+	runnersWg.Add(1)
+	go func() {
+		defer runnersWg.Done()
+		riaRunner.Run(ctx)
+	}()
+
+	runnersWg.Add(1)
+	go func() {
+		defer runnersWg.Done()
+		schedule.NewScheduler(riaSchedule, riaRunner, log).Run(ctx)
+	}()
+
+	if revisions != nil {
+		runnersWg.Add(1)
+		go func() {
+			defer runnersWg.Done()
+			revision.NewRechecker(ria.Source, revisions, riaScrapper,
+				seconds(cfg.RevisionCheckInterval), cfg.RevisionBatch, log).Run(ctx)
+		}()
+	}
+
 	<-ctx.Done()
 	log.Info("start graceful shutdown")
-	done = true
+	runnersWg.Wait()
+	cancelBg()
 	wg.Wait()
 	log.Info("end graceful shutdown")
 }
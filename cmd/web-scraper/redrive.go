@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/STTM-NSU/web-scrapper/internal/deadletter"
+	"github.com/STTM-NSU/web-scrapper/internal/ria"
+)
+
+const _redriveCommand = "redrive"
+
+// redrive fetches the urls that are in the dead letter queue at the start again. An entry is removed
+// from the queue only after its url was fetched, urls that fail again are pushed back by the scrapper.
+func redrive(ctx context.Context, log *slog.Logger, queue *deadletter.Queue, riaScrapper *ria.Scrapper) error {
+	items, err := queue.Range(ctx)
+	if err != nil {
+		return err
+	}
+
+	var days []string
+	byDay := make(map[string][]deadletter.Item)
+	for _, item := range items {
+		if item.Source != ria.Source {
+			log.Error("unknown source", slog.String("source", item.Source), slog.String("url", item.Url))
+			continue
+		}
+		if _, ok := byDay[item.Day]; !ok {
+			days = append(days, item.Day)
+		}
+		byDay[item.Day] = append(byDay[item.Day], item)
+	}
+
+	log.Info("start redrive", slog.Int("urls", len(items)), slog.Int("days", len(days)))
+	for _, day := range days {
+		urls := make([]string, len(byDay[day]))
+		for i, item := range byDay[day] {
+			urls[i] = item.Url
+		}
+		unfinished, err := riaScrapper.Redrive(ctx, day, urls)
+		if err != nil {
+			return fmt.Errorf("can't redrive day %s: %w", day, err)
+		}
+
+		keep := make(map[string]struct{}, len(unfinished))
+		for _, u := range unfinished {
+			keep[u] = struct{}{}
+		}
+		for _, item := range byDay[day] {
+			if _, ok := keep[item.Url]; ok {
+				continue
+			}
+			// the fetched urls are removed even on shutdown, so they are not published twice
+			if err := queue.Remove(context.WithoutCancel(ctx), item); err != nil {
+				return err
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
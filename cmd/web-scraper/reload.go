@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/STTM-NSU/web-scrapper/internal/breaker"
+	"github.com/STTM-NSU/web-scrapper/internal/concurrency"
+	"github.com/STTM-NSU/web-scrapper/internal/config"
+	"github.com/STTM-NSU/web-scrapper/internal/proxy"
+	"github.com/STTM-NSU/web-scrapper/internal/ria"
+)
+
+// reloader loads the config again on SIGHUP with the same arguments and applies the fields
+// that can be changed at runtime. Changes of the other fields are logged and wait for a restart.
+type reloader struct {
+	cfg         config.Config
+	args        []string
+	level       *slog.LevelVar
+	limiter     *proxy.Limiter
+	concurrency *concurrency.Controller
+	breaker     *breaker.Breaker
+	riaScrapper *ria.Scrapper
+	log         *slog.Logger
+}
+
+func (r *reloader) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reload()
+		}
+	}
+}
+
+func (r *reloader) reload() {
+	next, _, err := config.Load(r.args)
+	if err != nil {
+		r.log.Error("can't reload config: " + err.Error())
+		return
+	}
+
+	cfg, applied, restart := config.Reload(r.cfg, next)
+	if len(restart) > 0 {
+		r.log.Warn("config fields changed, restart to apply them", slog.Any("fields", restart))
+	}
+	if len(applied) == 0 {
+		r.log.Info("config reloaded, nothing to apply")
+		return
+	}
+
+	if cfg.LogLevel != r.cfg.LogLevel {
+		// the level is validated by config.Load
+		_ = r.level.UnmarshalText([]byte(cfg.LogLevel))
+	}
+	r.limiter.SetRate(cfg.ProxyRequestsPerSecond, cfg.ProxyBurst)
+	r.concurrency.SetConfig(concurrencyConfig(cfg))
+	r.breaker.SetConfig(breakerConfig(cfg))
+	r.riaScrapper.SetConfig(riaConfig(cfg))
+
+	r.cfg = cfg
+	r.log.Info("config reloaded", slog.Any("fields", applied), slog.Any("config", cfg.Redacted()))
+}
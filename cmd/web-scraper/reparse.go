@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/STTM-NSU/web-scrapper/internal/archive"
+	"github.com/STTM-NSU/web-scrapper/internal/config"
+	"github.com/STTM-NSU/web-scrapper/internal/db/redis"
+	"github.com/STTM-NSU/web-scrapper/internal/publish"
+	"github.com/STTM-NSU/web-scrapper/internal/ria"
+)
+
+const _reparseCommand = "reparse"
+
+// reparse runs the extraction over the archived articles of the days from..to without the proxies.
+// The articles are published as updates to Redis or written to the --out file.
+func reparse(ctx context.Context, cfg config.Config, args []string, log *slog.Logger) error {
+	fs := flag.NewFlagSet(_reparseCommand, flag.ContinueOnError)
+	fromFlag := fs.String("from", "", "first day, 2006-01-02")
+	toFlag := fs.String("to", "", "last day, 2006-01-02, from if empty")
+	out := fs.String("out", "", "JSON lines file to write the articles to instead of Redis, - is stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	from, err := time.Parse(time.DateOnly, *fromFlag)
+	if err != nil {
+		return fmt.Errorf("bad from: %w", err)
+	}
+	to := from
+	if *toFlag != "" {
+		if to, err = time.Parse(time.DateOnly, *toFlag); err != nil {
+			return fmt.Errorf("bad to: %w", err)
+		}
+	}
+	if to.Before(from) {
+		return fmt.Errorf("to %s is before from %s", *toFlag, *fromFlag)
+	}
+
+	var publisher publish.Publisher
+	if *out != "" {
+		file, err := publish.NewFile(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		publisher = file
+	} else {
+		rdb, err := redis.Connect(ctx, redisConfig(cfg), log)
+		if err != nil {
+			return fmt.Errorf("can't connect to redis: %w", err)
+		}
+		defer rdb.Close()
+		publisher = publish.NewRedis(rdb)
+	}
+
+	// only the extraction of the scrapper is used, nothing is fetched
+	riaScrapper := ria.NewScrapper(publisher, log, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, riaConfig(cfg))
+	return riaScrapper.Reparse(ctx, archive.NewReader(cfg.ArchiveDir, cfg.ArchivePrefix), from, to, publisher)
+}
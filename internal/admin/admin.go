@@ -0,0 +1,303 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/STTM-NSU/web-scrapper/internal/deadletter"
+	"github.com/STTM-NSU/web-scrapper/internal/health"
+	"github.com/STTM-NSU/web-scrapper/internal/proxy"
+	"github.com/STTM-NSU/web-scrapper/internal/runner"
+)
+
+const shutdownTimeOut = 5 * time.Second
+
+type Config struct {
+	Host string
+	Port int
+	// ProbesHost and ProbesPort are where the metrics and the health probes are served
+	ProbesHost string
+	ProbesPort int
+}
+
+type ProxyState struct {
+	Host    string      `json:"host"`
+	Daily   proxy.Usage `json:"daily"`
+	Monthly proxy.Usage `json:"monthly"`
+}
+
+type State struct {
+	Sources    []runner.State `json:"sources"`
+	DeadLetter int64          `json:"dead_letter"`
+	Sessions   int            `json:"sessions"`
+	Proxies    proxy.Pool     `json:"proxies"`
+	Usage      []ProxyState   `json:"usage"`
+	LogLevel   string         `json:"log_level"`
+}
+
+// Server is the admin HTTP API to look at the state of the scrapper and control it at runtime.
+// The metrics and the health probes are served on their own address, so that the API itself
+// can be kept private.
+type Server struct {
+	srv     *http.Server
+	probes  *http.Server
+	runners map[string]*runner.Runner
+
+	proxySwitcher *proxy.MyRoundRobinSwitcher
+	accountant    *proxy.Accountant
+	deadLetter    *deadletter.Queue
+	level         *slog.LevelVar
+	logger        *slog.Logger
+}
+
+func NewServer(cfg Config,
+	runners []*runner.Runner,
+	proxySwitcher *proxy.MyRoundRobinSwitcher,
+	accountant *proxy.Accountant,
+	deadLetter *deadletter.Queue,
+	level *slog.LevelVar,
+	checker *health.Checker,
+	logger *slog.Logger) *Server {
+	s := &Server{
+		runners:       make(map[string]*runner.Runner, len(runners)),
+		proxySwitcher: proxySwitcher,
+		accountant:    accountant,
+		deadLetter:    deadLetter,
+		level:         level,
+		logger:        logger,
+	}
+	for _, r := range runners {
+		s.runners[r.Source()] = r
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /state", s.state)
+	mux.HandleFunc("POST /sources/{source}/pause", s.pause)
+	mux.HandleFunc("POST /sources/{source}/resume", s.resume)
+	mux.HandleFunc("POST /sources/{source}/rescrape", s.rescrape)
+	mux.HandleFunc("POST /proxies", s.addProxy)
+	mux.HandleFunc("DELETE /proxies/{proxy}", s.removeProxy)
+	mux.HandleFunc("GET /log-level", s.getLogLevel)
+	mux.HandleFunc("PUT /log-level", s.setLogLevel)
+
+	probes := http.NewServeMux()
+	probes.Handle("GET /metrics", promhttp.Handler())
+	probes.HandleFunc("GET /healthz", checker.Liveness)
+	probes.HandleFunc("GET /readyz", checker.Readiness)
+
+	s.srv = &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	s.probes = &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", cfg.ProbesHost, cfg.ProbesPort),
+		Handler:           probes,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return s
+}
+
+// Run serves the admin API and the probes until ctx is canceled or one of them fails.
+func (s *Server) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errCh := make(chan error, 2)
+	for name, srv := range map[string]*http.Server{"admin": s.srv, "probes": s.probes} {
+		go func() {
+			err := s.serve(ctx, name, srv)
+			// the other server is stopped too
+			cancel()
+			errCh <- err
+		}()
+	}
+	return errors.Join(<-errCh, <-errCh)
+}
+
+func (s *Server) serve(ctx context.Context, name string, srv *http.Server) error {
+	errCh := make(chan error, 1)
+	go func() {
+		s.logger.Info(name+" server started", slog.String("addr", srv.Addr))
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("can't serve %s: %w", name, err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeOut)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("can't shutdown %s: %w", name, err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("can't serve %s: %w", name, err)
+	}
+	return nil
+}
+
+func (s *Server) state(w http.ResponseWriter, r *http.Request) {
+	deadLetter, err := s.deadLetter.Len(r.Context())
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	state := State{
+		DeadLetter: deadLetter,
+		Sessions:   s.proxySwitcher.Sessions().Len(),
+		Proxies:    s.proxySwitcher.Pool(),
+		LogLevel:   s.level.Level().String(),
+	}
+	for _, rn := range s.runners {
+		state.Sources = append(state.Sources, rn.State())
+	}
+	for _, host := range s.proxySwitcher.Hosts() {
+		daily, monthly := s.accountant.Usage(host)
+		state.Usage = append(state.Usage, ProxyState{Host: host, Daily: daily, Monthly: monthly})
+	}
+	s.json(w, http.StatusOK, state)
+}
+
+func (s *Server) runner(w http.ResponseWriter, r *http.Request) (*runner.Runner, bool) {
+	rn, ok := s.runners[r.PathValue("source")]
+	if !ok {
+		s.error(w, http.StatusNotFound, fmt.Errorf("unknown source %s", r.PathValue("source")))
+	}
+	return rn, ok
+}
+
+func (s *Server) pause(w http.ResponseWriter, r *http.Request) {
+	rn, ok := s.runner(w, r)
+	if !ok {
+		return
+	}
+	rn.Pause()
+	s.json(w, http.StatusOK, rn.State())
+}
+
+func (s *Server) resume(w http.ResponseWriter, r *http.Request) {
+	rn, ok := s.runner(w, r)
+	if !ok {
+		return
+	}
+	rn.Resume()
+	s.json(w, http.StatusOK, rn.State())
+}
+
+// rescrape queues the days from the "from" query parameter to the "to" one, both are YYYY-MM-DD.
+// "to" defaults to "from".
+func (s *Server) rescrape(w http.ResponseWriter, r *http.Request) {
+	rn, ok := s.runner(w, r)
+	if !ok {
+		return
+	}
+
+	from, err := time.Parse(time.DateOnly, r.URL.Query().Get("from"))
+	if err != nil {
+		s.error(w, http.StatusBadRequest, fmt.Errorf("bad from: %w", err))
+		return
+	}
+	to := from
+	if v := r.URL.Query().Get("to"); v != "" {
+		to, err = time.Parse(time.DateOnly, v)
+		if err != nil {
+			s.error(w, http.StatusBadRequest, fmt.Errorf("bad to: %w", err))
+			return
+		}
+	}
+	if err := rn.Rescrape(from, to); err != nil {
+		s.error(w, http.StatusBadRequest, err)
+		return
+	}
+	s.json(w, http.StatusAccepted, rn.State())
+}
+
+// addProxy adds the proxy from the "url" query parameter in the same host:port form as in the env.
+func (s *Server) addProxy(w http.ResponseWriter, r *http.Request) {
+	u, err := parseProxy(r.URL.Query().Get("url"))
+	if err != nil {
+		s.error(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.accountant.Load(r.Context(), u.Host); err != nil {
+		s.error(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.proxySwitcher.GetCmdChan() <- proxy.CommandMessage{Cmd: proxy.Add, Url: u}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) removeProxy(w http.ResponseWriter, r *http.Request) {
+	host := r.PathValue("proxy")
+	for _, u := range s.proxySwitcher.URLs() {
+		if u.Host == host {
+			s.proxySwitcher.GetCmdChan() <- proxy.CommandMessage{Cmd: proxy.Remove, Url: u}
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+	}
+	s.error(w, http.StatusNotFound, fmt.Errorf("unknown proxy %s", host))
+}
+
+func (s *Server) getLogLevel(w http.ResponseWriter, _ *http.Request) {
+	s.json(w, http.StatusOK, map[string]string{"level": s.level.Level().String()})
+}
+
+// setLogLevel sets the level from the "level" query parameter: debug, info, warn or error.
+func (s *Server) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(r.URL.Query().Get("level"))); err != nil {
+		s.error(w, http.StatusBadRequest, err)
+		return
+	}
+	s.level.Set(level)
+	s.logger.Info("log level changed", slog.String("level", level.String()))
+	s.json(w, http.StatusOK, map[string]string{"level": level.String()})
+}
+
+func (s *Server) json(w http.ResponseWriter, status int, v any) {
+	data, err := sonic.Marshal(v)
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		s.logger.Error("can't write admin response: " + err.Error())
+	}
+}
+
+func (s *Server) error(w http.ResponseWriter, status int, err error) {
+	http.Error(w, err.Error(), status)
+}
+
+func parseProxy(v string) (*url.URL, error) {
+	if v == "" {
+		return nil, fmt.Errorf("no proxy url")
+	}
+	if !strings.Contains(v, "://") {
+		v = "http://" + v
+	}
+	u, err := url.Parse(v)
+	if err != nil {
+		return nil, fmt.Errorf("bad proxy url: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("bad proxy url %s: no host", v)
+	}
+	return u, nil
+}
@@ -0,0 +1,216 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const warcVersion = "WARC/1.1"
+
+type Config struct {
+	Dir    string
+	Prefix string
+	// a new file is started when the current one grows over MaxSize bytes or gets older than MaxAge
+	MaxSize int64
+	MaxAge  time.Duration
+}
+
+// Response is a fetched page to archive.
+type Response struct {
+	URL    string
+	Status int
+	Header http.Header
+	Body   []byte
+	// Proxy is the host of the proxy, without the credentials
+	Proxy     string
+	FetchedAt time.Time
+}
+
+// Writer writes the responses as WARC response records to rotating files in the directory.
+// Every record is a separate gzip member, so the files can be read from any record on.
+type Writer struct {
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	opened  time.Time
+	counter int
+	host    string
+
+	cfg    Config
+	logger *slog.Logger
+}
+
+func NewWriter(cfg Config, logger *slog.Logger) (*Writer, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("can't create archive dir: %w", err)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &Writer{
+		host:   host,
+		cfg:    cfg,
+		logger: logger,
+	}, nil
+}
+
+// Write archives the response and returns the WARC-Record-ID of its record.
+func (w *Writer) Write(resp Response) (string, error) {
+	id, err := newRecordID()
+	if err != nil {
+		return "", err
+	}
+	record, err := responseRecord(id, resp)
+	if err != nil {
+		return "", err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.rotate(); err != nil {
+		return "", err
+	}
+	n, err := w.file.Write(record)
+	w.size += int64(n)
+	if err != nil {
+		return "", fmt.Errorf("can't write archive record: %w", err)
+	}
+	return id, nil
+}
+
+func (w *Writer) rotate() error {
+	if w.file != nil && w.size < w.cfg.MaxSize && time.Since(w.opened) < w.cfg.MaxAge {
+		return nil
+	}
+	if err := w.close(); err != nil {
+		return err
+	}
+
+	w.counter++
+	now := time.Now().UTC()
+	// the host keeps the names of the replicas writing to a shared dir apart
+	name := fmt.Sprintf("%s-%s-%05d-%s.warc.gz", w.cfg.Prefix, now.Format("20060102150405"), w.counter, w.host)
+	f, err := os.OpenFile(filepath.Join(w.cfg.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("can't create archive file: %w", err)
+	}
+	w.file, w.size, w.opened = f, 0, now
+
+	info, err := warcinfoRecord(name, now)
+	if err != nil {
+		return err
+	}
+	n, err := w.file.Write(info)
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("can't write warcinfo: %w", err)
+	}
+	w.logger.Info("archive file started", slog.String("file", name))
+	return nil
+}
+
+func (w *Writer) close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	if err != nil {
+		return fmt.Errorf("can't close archive file: %w", err)
+	}
+	return nil
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.close()
+}
+
+func responseRecord(id string, resp Response) ([]byte, error) {
+	var block bytes.Buffer
+	fmt.Fprintf(&block, "HTTP/1.1 %d %s\r\n", resp.Status, http.StatusText(resp.Status))
+	header := resp.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	// the body is stored decoded
+	header.Del("Content-Encoding")
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(resp.Body)))
+	if err := header.Write(&block); err != nil {
+		return nil, fmt.Errorf("can't write response header: %w", err)
+	}
+	block.WriteString("\r\n")
+	block.Write(resp.Body)
+
+	fields := [][2]string{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", id},
+		{"WARC-Date", resp.FetchedAt.UTC().Format(time.RFC3339)},
+		{"WARC-Target-URI", resp.URL},
+		{"Content-Type", "application/http;msgtype=response"},
+	}
+	if resp.Proxy != "" {
+		fields = append(fields, [2]string{"Scrapper-Proxy", resp.Proxy})
+	}
+	return record(fields, block.Bytes())
+}
+
+func warcinfoRecord(filename string, now time.Time) ([]byte, error) {
+	id, err := newRecordID()
+	if err != nil {
+		return nil, err
+	}
+	return record([][2]string{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", id},
+		{"WARC-Date", now.Format(time.RFC3339)},
+		{"WARC-Filename", filename},
+		{"Content-Type", "application/warc-fields"},
+	}, []byte("software: web-scrapper\r\nformat: WARC File Format 1.1\r\n"))
+}
+
+// record returns the gzip member of the record.
+func record(fields [][2]string, block []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+
+	var head bytes.Buffer
+	head.WriteString(warcVersion + "\r\n")
+	for _, f := range fields {
+		head.WriteString(f[0] + ": " + f[1] + "\r\n")
+	}
+	head.WriteString("Content-Length: " + strconv.Itoa(len(block)) + "\r\n\r\n")
+
+	for _, part := range [][]byte{head.Bytes(), block, []byte("\r\n\r\n")} {
+		if _, err := gz.Write(part); err != nil {
+			return nil, fmt.Errorf("can't compress record: %w", err)
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("can't compress record: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func newRecordID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("can't generate record id: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
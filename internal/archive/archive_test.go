@@ -0,0 +1,172 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testResponses() []Response {
+	fetchedAt := time.Date(2024, time.March, 10, 12, 30, 0, 0, time.UTC)
+	return []Response{
+		{
+			URL:       "https://www.rbc.ru/politics/10/03/2024/1",
+			Status:    http.StatusOK,
+			Header:    http.Header{"Content-Type": {"text/html; charset=utf-8"}, "Content-Encoding": {"gzip"}},
+			Body:      []byte("<html><h1>Заголовок</h1></html>"),
+			Proxy:     "proxy.example.com:8080",
+			FetchedAt: fetchedAt,
+		},
+		{
+			URL:       "https://www.rbc.ru/economics/10/03/2024/2",
+			Status:    http.StatusOK,
+			Body:      []byte("WARC/1.1\r\n\r\n\r\n\r\n"),
+			FetchedAt: fetchedAt.Add(time.Minute),
+		},
+		{
+			URL:       "https://www.rbc.ru/society/10/03/2024/3",
+			Status:    http.StatusNotFound,
+			FetchedAt: fetchedAt.Add(2 * time.Minute),
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxSize   int64
+		wantFiles int
+	}{
+		{"one file", 1 << 20, 1},
+		{"file per record", 1, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w, err := NewWriter(Config{Dir: dir, Prefix: "rbc", MaxSize: tt.maxSize, MaxAge: time.Hour}, slog.New(slog.DiscardHandler))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := testResponses()
+			var ids []string
+			for _, resp := range want {
+				id, err := w.Write(resp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, id)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			files, _ := filepath.Glob(filepath.Join(dir, "rbc-*.warc.gz"))
+			if len(files) != tt.wantFiles {
+				t.Errorf("got %d files, want %d", len(files), tt.wantFiles)
+			}
+
+			var i int
+			err = NewReader(dir, "rbc").Scan(context.Background(), func(id string, got Response) error {
+				if i >= len(want) {
+					t.Fatalf("got more than %d records", len(want))
+				}
+				if id != ids[i] {
+					t.Errorf("record %d: id = %s, want %s", i, id, ids[i])
+				}
+				checkResponse(t, got, want[i])
+				i++
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i != len(want) {
+				t.Errorf("got %d records, want %d", i, len(want))
+			}
+		})
+	}
+}
+
+func checkResponse(t *testing.T, got, want Response) {
+	t.Helper()
+	if got.URL != want.URL || got.Status != want.Status || got.Proxy != want.Proxy || !got.FetchedAt.Equal(want.FetchedAt) {
+		t.Errorf("got %s %d %q %s, want %s %d %q %s", got.URL, got.Status, got.Proxy, got.FetchedAt,
+			want.URL, want.Status, want.Proxy, want.FetchedAt)
+	}
+	if !bytes.Equal(got.Body, want.Body) {
+		t.Errorf("%s: body = %q, want %q", want.URL, got.Body, want.Body)
+	}
+	// the body is stored decoded
+	if got.Header.Get("Content-Encoding") != "" {
+		t.Errorf("%s: Content-Encoding is kept", want.URL)
+	}
+	if ct := want.Header.Get("Content-Type"); got.Header.Get("Content-Type") != ct {
+		t.Errorf("%s: Content-Type = %q, want %q", want.URL, got.Header.Get("Content-Type"), ct)
+	}
+}
+
+func TestScanUnfinishedRecord(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(Config{Dir: dir, Prefix: "rbc", MaxSize: 1 << 20, MaxAge: time.Hour}, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses := testResponses()
+	if _, err := w.Write(responses[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a record of a second response cut in the middle, as if it is being written
+	record, err := responseRecord("<urn:uuid:cut>", responses[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "rbc-*.warc.gz"))
+	f, err := os.OpenFile(files[0], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(record[:len(record)/2]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	var n int
+	err = NewReader(dir, "rbc").Scan(context.Background(), func(string, Response) error {
+		n++
+		return nil
+	})
+	if err != nil || n != 1 {
+		t.Errorf("Scan() = %v after %d records, want nil after 1", err, n)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	tr := NewTransport()
+	want := testResponses()[0]
+	tr.Put(want)
+
+	req, _ := http.NewRequest(http.MethodGet, want.URL, nil)
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != want.Status || !bytes.Equal(body, want.Body) {
+		t.Errorf("got %d %q, want %d %q", resp.StatusCode, body, want.Status, want.Body)
+	}
+
+	// a response is served once
+	if _, err := tr.RoundTrip(req); err == nil {
+		t.Error("second RoundTrip() succeeded")
+	}
+}
@@ -0,0 +1,153 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reader reads the responses back from the archive files with the prefix in the dir.
+type Reader struct {
+	dir    string
+	prefix string
+}
+
+func NewReader(dir, prefix string) *Reader {
+	return &Reader{
+		dir:    dir,
+		prefix: prefix,
+	}
+}
+
+// Scan calls fn for every response record in the order the files were started.
+func (r *Reader) Scan(ctx context.Context, fn func(id string, resp Response) error) error {
+	files, err := filepath.Glob(filepath.Join(r.dir, r.prefix+"-*.warc.gz"))
+	if err != nil {
+		return fmt.Errorf("can't list archive files: %w", err)
+	}
+	// the names start with the time the file was started
+	slices.Sort(files)
+	for _, name := range files {
+		if err := scanFile(ctx, name, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanFile(ctx context.Context, name string, fn func(id string, resp Response) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("can't open archive file: %w", err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can't read %s: %w", name, err)
+	}
+	defer gz.Close()
+
+	br := bufio.NewReader(gz)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		header, block, err := readRecord(br)
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			// the last record of the current file may be still written
+			return nil
+		case err != nil:
+			return fmt.Errorf("can't read %s: %w", name, err)
+		}
+		if header.Get("WARC-Type") != "response" {
+			continue
+		}
+
+		resp, err := parseResponse(header, block)
+		if err != nil {
+			return fmt.Errorf("bad record %s in %s: %w", header.Get("WARC-Record-ID"), name, err)
+		}
+		if err := fn(header.Get("WARC-Record-ID"), resp); err != nil {
+			return err
+		}
+	}
+}
+
+func readRecord(br *bufio.Reader) (textproto.MIMEHeader, []byte, error) {
+	tp := textproto.NewReader(br)
+	version, err := tp.ReadLine()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, nil, fmt.Errorf("%q is not a WARC record", version)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, noEOF(err)
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad Content-Length: %w", err)
+	}
+	block := make([]byte, length)
+	if _, err := io.ReadFull(br, block); err != nil {
+		return nil, nil, noEOF(err)
+	}
+	if _, err := br.Discard(4); err != nil {
+		return nil, nil, noEOF(err)
+	}
+	return header, block, nil
+}
+
+// noEOF turns EOF inside a record into ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func parseResponse(header textproto.MIMEHeader, block []byte) (Response, error) {
+	httpResp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(block)), nil)
+	if err != nil {
+		return Response{}, fmt.Errorf("can't parse response: %w", err)
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return Response{}, fmt.Errorf("can't read body: %w", err)
+	}
+	fetchedAt, err := time.Parse(time.RFC3339, header.Get("WARC-Date"))
+	if err != nil {
+		return Response{}, fmt.Errorf("bad WARC-Date: %w", err)
+	}
+	return Response{
+		URL:       header.Get("WARC-Target-URI"),
+		Status:    httpResp.StatusCode,
+		Header:    httpResp.Header,
+		Body:      body,
+		Proxy:     header.Get("Scrapper-Proxy"),
+		FetchedAt: fetchedAt,
+	}, nil
+}
@@ -0,0 +1,53 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Transport serves the requests from the responses put into it and never goes to the network.
+// A response is served once and forgotten.
+type Transport struct {
+	mu        sync.Mutex
+	responses map[string]Response
+}
+
+func NewTransport() *Transport {
+	return &Transport{responses: make(map[string]Response)}
+}
+
+func (t *Transport) Put(resp Response) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.responses[resp.URL] = resp
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	resp, ok := t.responses[req.URL.String()]
+	delete(t.responses, req.URL.String())
+	t.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%s is not in the archive", req.URL)
+	}
+
+	header := resp.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(resp.Status) + " " + http.StatusText(resp.Status),
+		StatusCode:    resp.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(resp.Body)),
+		ContentLength: int64(len(resp.Body)),
+		Request:       req,
+	}, nil
+}
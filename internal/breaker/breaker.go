@@ -0,0 +1,237 @@
+package breaker
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/STTM-NSU/web-scrapper/internal/metrics"
+	"github.com/STTM-NSU/web-scrapper/internal/retry"
+)
+
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type Config struct {
+	Window      time.Duration
+	MinRequests int
+	FailureRate float64
+	OpenTimeout time.Duration
+}
+
+type domain struct {
+	state       State
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probing     bool
+	changed     chan struct{}
+}
+
+// Breaker is a circuit breaker for every target domain. It opens when the share of failed
+// requests in the window crosses the threshold, holds all requests to the domain while open,
+// and then lets a single probe through. The domain is closed again only if the probe succeeds.
+type Breaker struct {
+	mu      sync.Mutex
+	domains map[string]*domain
+
+	cfg    Config
+	logger *slog.Logger
+}
+
+func New(cfg Config, logger *slog.Logger) *Breaker {
+	return &Breaker{
+		domains: make(map[string]*domain),
+		cfg:     cfg,
+		logger:  logger,
+	}
+}
+
+func (b *Breaker) SetConfig(cfg Config) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cfg = cfg
+}
+
+func (b *Breaker) get(host string) *domain {
+	d, ok := b.domains[host]
+	if !ok {
+		d = &domain{
+			windowStart: time.Now(),
+			changed:     make(chan struct{}),
+		}
+		b.domains[host] = d
+	}
+	return d
+}
+
+// Allow blocks until a request to host may be sent. It returns true if the request is the probe.
+func (b *Breaker) Allow(ctx context.Context, host string) (bool, error) {
+	for {
+		b.mu.Lock()
+		d := b.get(host)
+
+		var wait <-chan time.Time
+		switch d.state {
+		case Closed:
+			b.mu.Unlock()
+			return false, nil
+		case Open:
+			left := time.Until(d.openedAt.Add(b.cfg.OpenTimeout))
+			if left <= 0 {
+				b.setState(host, d, HalfOpen)
+				b.mu.Unlock()
+				continue
+			}
+			wait = time.After(left)
+		case HalfOpen:
+			if !d.probing {
+				d.probing = true
+				b.mu.Unlock()
+				return true, nil
+			}
+		}
+		ch := d.changed
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-ch:
+		case <-wait:
+		}
+	}
+}
+
+// Record counts the result of a request to host.
+func (b *Breaker) Record(host string, probe bool, class retry.Class) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	d := b.get(host)
+	failed := isFailure(class)
+	switch d.state {
+	case HalfOpen:
+		if !probe {
+			return
+		}
+		d.probing = false
+		switch {
+		case class == retry.Canceled:
+			d.notify()
+		case failed:
+			b.setState(host, d, Open)
+		default:
+			b.setState(host, d, Closed)
+		}
+	case Closed:
+		if class == retry.Canceled {
+			return
+		}
+		if time.Since(d.windowStart) > b.cfg.Window {
+			d.windowStart = time.Now()
+			d.requests, d.failures = 0, 0
+		}
+		d.requests++
+		if failed {
+			d.failures++
+		}
+		if d.requests >= b.cfg.MinRequests && float64(d.failures)/float64(d.requests) >= b.cfg.FailureRate {
+			b.setState(host, d, Open)
+		}
+	}
+}
+
+// State returns the state of the host. Failures of requests to a host that is not closed
+// are caused by the host, not by the proxies.
+func (b *Breaker) State(host string) State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.get(host).state
+}
+
+func (b *Breaker) States() map[string]State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	states := make(map[string]State, len(b.domains))
+	for host, d := range b.domains {
+		states[host] = d.state
+	}
+	return states
+}
+
+func (b *Breaker) setState(host string, d *domain, state State) {
+	b.logger.Info("circuit breaker state changed",
+		slog.String("host", host),
+		slog.String("from", d.state.String()),
+		slog.String("to", state.String()),
+		slog.Int("requests", d.requests),
+		slog.Int("failures", d.failures))
+
+	d.state = state
+	metrics.BreakerState.WithLabelValues(host).Set(float64(state))
+	switch state {
+	case Open:
+		d.openedAt = time.Now()
+	case Closed:
+		d.windowStart = time.Now()
+		d.requests, d.failures = 0, 0
+	}
+	d.notify()
+}
+
+func (d *domain) notify() {
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+func isFailure(class retry.Class) bool {
+	switch class {
+	case retry.Server, retry.Proxy, retry.Timeout, retry.Network:
+		return true
+	default:
+		return false
+	}
+}
+
+// Transport holds requests to domains with an open circuit.
+type Transport struct {
+	Base    http.RoundTripper
+	Breaker *Breaker
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	probe, err := t.Breaker.Allow(req.Context(), host)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.Base.RoundTrip(req)
+
+	var status int
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	t.Breaker.Record(host, probe, retry.Classify(status, err))
+	return resp, err
+}
@@ -0,0 +1,130 @@
+package cassette
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const (
+	Record = "record"
+	Replay = "replay"
+)
+
+// Transport records the responses of Base to the cassette in Dir, or in the replay mode serves
+// the requests from the cassette without Base, so a crawl can be repeated offline.
+// A response is kept per method and url, the last one recorded wins.
+type Transport struct {
+	Base http.RoundTripper
+	Dir  string
+	Mode string
+}
+
+// Wrap returns base with the cassette of the mode in dir, base as is if the mode is empty.
+func Wrap(base http.RoundTripper, mode, dir string) http.RoundTripper {
+	if mode == "" {
+		return base
+	}
+	return &Transport{
+		Base: base,
+		Dir:  dir,
+		Mode: mode,
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch t.Mode {
+	case Record:
+		return t.record(req)
+	case Replay:
+		return t.replay(req)
+	default:
+		return nil, fmt.Errorf("unknown cassette mode %s", t.Mode)
+	}
+}
+
+func (t *Transport) path(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.Method + " " + req.URL.String()))
+	return filepath.Join(t.Dir, hex.EncodeToString(sum[:])+".http")
+}
+
+func (t *Transport) record(req *http.Request) (*http.Response, error) {
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.TransferEncoding = nil
+
+	if err := t.save(req, resp, body); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *Transport) save(req *http.Request, resp *http.Response, body []byte) error {
+	if err := os.MkdirAll(t.Dir, 0o755); err != nil {
+		return fmt.Errorf("can't create cassette dir: %w", err)
+	}
+	var buf bytes.Buffer
+	saved := *resp
+	saved.Body = io.NopCloser(bytes.NewReader(body))
+	if err := saved.Write(&buf); err != nil {
+		return fmt.Errorf("can't dump response: %w", err)
+	}
+
+	// the file is renamed into place, so a concurrent replay never reads a half written one
+	tmp, err := os.CreateTemp(t.Dir, "*.tmp")
+	if err != nil {
+		return fmt.Errorf("can't record response: %w", err)
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("can't record response: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("can't record response: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), t.path(req)); err != nil {
+		return fmt.Errorf("can't record response: %w", err)
+	}
+	return nil
+}
+
+func (t *Transport) replay(req *http.Request) (*http.Response, error) {
+	f, err := os.Open(t.path(req))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s %s is not in the cassette", req.Method, req.URL)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't open cassette: %w", err)
+	}
+	defer f.Close()
+
+	resp, err := http.ReadResponse(bufio.NewReader(f), req)
+	if err != nil {
+		return nil, fmt.Errorf("can't read cassette: %w", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("can't read cassette: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
@@ -0,0 +1,136 @@
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const dayTTL = 30 * 24 * time.Hour
+
+// days are YYYYMMDD, so they are compared as strings
+var setNextScript = redis.NewScript(`
+local cur = redis.call("get", KEYS[1])
+if not cur or cur < ARGV[1] then
+	redis.call("set", KEYS[1], ARGV[1])
+	return 1
+end
+return 0`)
+
+// Store keeps the progress of the sources in Redis: the next day of the backfill, the scraped days
+// and, for a day interrupted by shutdown, the urls already published and the ones left unfinished.
+type Store struct {
+	rdb       redis.UniversalClient
+	keyPrefix string
+}
+
+func NewStore(rdb redis.UniversalClient, keyPrefix string) *Store {
+	return &Store{
+		rdb:       rdb,
+		keyPrefix: keyPrefix,
+	}
+}
+
+func (s *Store) nextKey(source string) string {
+	return s.keyPrefix + ":" + source + ":next"
+}
+
+// the keys of a day share the hash tag to be in the same slot of a cluster
+func (s *Store) doneKey(source string) string {
+	return s.keyPrefix + ":" + source + ":done"
+}
+
+func (s *Store) publishedKey(source, day string) string {
+	return s.keyPrefix + ":{" + source + ":" + day + "}:published"
+}
+
+func (s *Store) unfinishedKey(source, day string) string {
+	return s.keyPrefix + ":{" + source + ":" + day + "}:unfinished"
+}
+
+// Next returns the next day of the backfill of the source, or "" if there is none yet.
+func (s *Store) Next(ctx context.Context, source string) (string, error) {
+	day, err := s.rdb.Get(ctx, s.nextKey(source)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("can't get next day: %w", err)
+	}
+	return day, nil
+}
+
+// SetNext moves the next day of the backfill forward, it is never moved back
+// by a replica that is behind the others.
+func (s *Store) SetNext(ctx context.Context, source, day string) error {
+	if err := setNextScript.Run(ctx, s.rdb, []string{s.nextKey(source)}, day).Err(); err != nil {
+		return fmt.Errorf("can't set next day: %w", err)
+	}
+	return nil
+}
+
+// MarkDone records that the past day is scraped completely.
+func (s *Store) MarkDone(ctx context.Context, source, day string) error {
+	if err := s.rdb.SAdd(ctx, s.doneKey(source), day).Err(); err != nil {
+		return fmt.Errorf("can't mark day done: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) IsDone(ctx context.Context, source, day string) (bool, error) {
+	done, err := s.rdb.SIsMember(ctx, s.doneKey(source), day).Result()
+	if err != nil {
+		return false, fmt.Errorf("can't check day done: %w", err)
+	}
+	return done, nil
+}
+
+// Save stores the mid-day checkpoint of the day.
+func (s *Store) Save(ctx context.Context, source, day string, published, unfinished []string) error {
+	pipe := s.rdb.TxPipeline()
+	if len(published) > 0 {
+		pipe.SAdd(ctx, s.publishedKey(source, day), toAny(published)...)
+		pipe.Expire(ctx, s.publishedKey(source, day), dayTTL)
+	}
+	pipe.Del(ctx, s.unfinishedKey(source, day))
+	if len(unfinished) > 0 {
+		pipe.SAdd(ctx, s.unfinishedKey(source, day), toAny(unfinished)...)
+		pipe.Expire(ctx, s.unfinishedKey(source, day), dayTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("can't save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Load returns the mid-day checkpoint of the day, both lists are empty if there is none.
+func (s *Store) Load(ctx context.Context, source, day string) ([]string, []string, error) {
+	published, err := s.rdb.SMembers(ctx, s.publishedKey(source, day)).Result()
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't load published urls: %w", err)
+	}
+	unfinished, err := s.rdb.SMembers(ctx, s.unfinishedKey(source, day)).Result()
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't load unfinished urls: %w", err)
+	}
+	return published, unfinished, nil
+}
+
+// Clear removes the mid-day checkpoint when the day is scraped completely.
+func (s *Store) Clear(ctx context.Context, source, day string) error {
+	if err := s.rdb.Del(ctx, s.publishedKey(source, day), s.unfinishedKey(source, day)).Err(); err != nil {
+		return fmt.Errorf("can't clear checkpoint: %w", err)
+	}
+	return nil
+}
+
+func toAny(values []string) []any {
+	res := make([]any, len(values))
+	for i, v := range values {
+		res[i] = v
+	}
+	return res
+}
@@ -0,0 +1,271 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/STTM-NSU/web-scrapper/internal/metrics"
+	"github.com/STTM-NSU/web-scrapper/internal/retry"
+)
+
+const (
+	AdjustInterval = 5 * time.Second
+	// congestion share of the responses in the interval after which the limit is cut
+	congestionThreshold = 0.05
+	decreaseFactor      = 0.5
+	increaseStep        = 1
+	// the live lane is active for that long after its last request
+	liveIdleAfter = 10 * time.Second
+)
+
+type Config struct {
+	Min      int
+	Max      int
+	PerProxy int
+	// BackfillShare is the share of the limit the backfill lane may use while the live lane is active
+	BackfillShare float64
+}
+
+// Controller limits the number of requests in flight. The limit is adjusted with AIMD:
+// it grows by one every interval with healthy responses and is cut in half when
+// throttling, server errors or timeouts cross the threshold.
+// Waiting live requests take free slots first, and while the live lane is active
+// the backfill lane is held to its share of the limit.
+type Controller struct {
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	lanes    [2]int
+	waiting  [2]int
+	liveSeen time.Time
+	changed  chan struct{}
+
+	succeeded int
+	congested int
+	saturated bool
+
+	cfg     Config
+	healthy func() int
+	logger  *slog.Logger
+}
+
+// NewController creates a controller. healthy returns the number of usable proxies,
+// that times cfg.PerProxy is the ceiling of the limit.
+func NewController(cfg Config, healthy func() int, logger *slog.Logger) *Controller {
+	return &Controller{
+		limit:   float64(cfg.Min),
+		changed: make(chan struct{}),
+		cfg:     cfg,
+		healthy: healthy,
+		logger:  logger,
+	}
+}
+
+// Acquire waits for a slot in the lane of ctx.
+func (c *Controller) Acquire(ctx context.Context) error {
+	lane := LaneFrom(ctx)
+	start := time.Now()
+
+	c.mu.Lock()
+	c.waiting[lane]++
+	for !c.admit(lane) {
+		c.saturated = true
+		ch := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			c.mu.Lock()
+			c.waiting[lane]--
+			c.notify()
+			c.mu.Unlock()
+			return ctx.Err()
+		case <-ch:
+		}
+		c.mu.Lock()
+	}
+	c.waiting[lane]--
+	c.inFlight++
+	c.lanes[lane]++
+	if lane == Live {
+		c.liveSeen = time.Now()
+	}
+	c.observe()
+	c.mu.Unlock()
+
+	metrics.LaneWait.WithLabelValues(lane.String()).Observe(time.Since(start).Seconds())
+	return nil
+}
+
+func (c *Controller) admit(lane Lane) bool {
+	limit := c.current()
+	if c.inFlight >= limit {
+		return false
+	}
+	if lane == Live {
+		return true
+	}
+	if c.waiting[Live] > 0 {
+		return false
+	}
+	if c.lanes[Live] > 0 || time.Since(c.liveSeen) < liveIdleAfter {
+		return c.lanes[Backfill] < max(1, int(float64(limit)*c.cfg.BackfillShare))
+	}
+	return true
+}
+
+func (c *Controller) observe() {
+	limit := float64(max(1, c.current()))
+	for _, lane := range []Lane{Backfill, Live} {
+		metrics.LaneInFlight.WithLabelValues(lane.String()).Set(float64(c.lanes[lane]))
+		metrics.LaneShare.WithLabelValues(lane.String()).Set(float64(c.lanes[lane]) / limit)
+	}
+}
+
+func (c *Controller) Release(lane Lane, class retry.Class) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inFlight--
+	c.lanes[lane]--
+	c.observe()
+	switch class {
+	case retry.Throttled, retry.Server, retry.Proxy, retry.Timeout:
+		c.congested++
+	case retry.Canceled:
+	default:
+		c.succeeded++
+	}
+	c.notify()
+}
+
+// SetConfig changes the bounds of the limit, the limit itself is moved into them at once.
+func (c *Controller) SetConfig(cfg Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cfg = cfg
+	c.limit = min(float64(cfg.Max), max(float64(cfg.Min), c.limit))
+	c.notify()
+}
+
+func (c *Controller) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current()
+}
+
+func (c *Controller) current() int {
+	return int(math.Floor(c.limit))
+}
+
+func (c *Controller) InFlight() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.inFlight
+}
+
+func (c *Controller) Run(ctx context.Context) {
+	ticker := time.NewTicker(AdjustInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.adjust()
+		}
+	}
+}
+
+func (c *Controller) adjust() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prev := c.current()
+	total := c.succeeded + c.congested
+	switch {
+	case total == 0:
+	case float64(c.congested)/float64(total) > congestionThreshold:
+		c.limit *= decreaseFactor
+	case c.saturated:
+		// the limit is only raised when requests actually waited for it
+		c.limit += increaseStep
+	}
+
+	ceiling := min(c.cfg.Max, max(c.cfg.Min, c.healthy()*c.cfg.PerProxy))
+	c.limit = min(float64(ceiling), max(float64(c.cfg.Min), c.limit))
+
+	if limit := c.current(); limit != prev {
+		c.logger.Info("concurrency limit changed",
+			slog.Int("from", prev),
+			slog.Int("to", limit),
+			slog.Int("succeeded", c.succeeded),
+			slog.Int("congested", c.congested),
+			slog.Int("ceiling", ceiling))
+		c.notify()
+	}
+	c.succeeded, c.congested, c.saturated = 0, 0, false
+}
+
+func (c *Controller) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// Transport holds a slot of the controller for every request in the lane of the request context
+// until its response body is closed.
+type Transport struct {
+	Base       http.RoundTripper
+	Controller *Controller
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	lane := LaneFrom(req.Context())
+	if err := t.Controller.Acquire(req.Context()); err != nil {
+		return nil, err
+	}
+	resp, err := t.Base.RoundTrip(req)
+
+	if err != nil || resp.Body == nil {
+		var status int
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Controller.Release(lane, retry.Classify(status, err))
+		return resp, err
+	}
+	// the slot is held until the body is read, a slow download is the load the limit is about
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func(err error) {
+		t.Controller.Release(lane, retry.Classify(resp.StatusCode, err))
+	}}
+	return resp, nil
+}
+
+// releasingBody releases the slot once, on the first read error or on close.
+type releasingBody struct {
+	io.ReadCloser
+	release func(err error)
+	once    sync.Once
+}
+
+func (b *releasingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		b.once.Do(func() { b.release(err) })
+	}
+	return n, err
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.release(nil) })
+	return err
+}
@@ -0,0 +1,31 @@
+package concurrency
+
+import "context"
+
+// Lane is the priority of a request. Live requests are let through first and backfill ones
+// get a share of the limit while there are live requests.
+type Lane int
+
+const (
+	Backfill Lane = iota
+	Live
+)
+
+func (l Lane) String() string {
+	if l == Live {
+		return "live"
+	}
+	return "backfill"
+}
+
+type laneKey struct{}
+
+func WithLane(ctx context.Context, lane Lane) context.Context {
+	return context.WithValue(ctx, laneKey{}, lane)
+}
+
+// LaneFrom returns the lane of the request context, backfill if it is not set.
+func LaneFrom(ctx context.Context) Lane {
+	lane, _ := ctx.Value(laneKey{}).(Lane)
+	return lane
+}
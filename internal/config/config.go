@@ -1,46 +1,598 @@
 package config
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/STTM-NSU/web-scrapper/internal/cassette"
+	"github.com/STTM-NSU/web-scrapper/internal/db/redis"
+	"github.com/STTM-NSU/web-scrapper/internal/dedup"
+	"github.com/STTM-NSU/web-scrapper/internal/proxy"
+	"github.com/STTM-NSU/web-scrapper/internal/schedule"
 )
 
+const DefaultPath = "./configs/config.yaml"
+
+// Config is loaded in layers: Default, then the YAML file, then the env vars, then the flags.
+// Every field can be set by the env var SCRAPPER_<FIELD> (or the one in the env tag) and
+// by the flag --<field>, both named after the yaml tag, except the proxy quotas and the schedules
+// that are YAML only.
+// Fields with the reload tag are applied on SIGHUP, the others need a restart.
 type Config struct {
+	// RedisMode is single, sentinel or cluster. RedisHost and RedisPort are used in the single mode,
+	// RedisAddrs, comma separated host:port, are the sentinels or the cluster seed nodes
+	RedisMode             string `yaml:"redisMode"`
+	RedisHost             string `yaml:"redisHost" env:"REDIS_HOST"`
+	RedisPort             int    `yaml:"redisPort" env:"REDIS_PORT"`
+	RedisAddrs            string `yaml:"redisAddrs"`
+	RedisMasterName       string `yaml:"redisMasterName"`
+	RedisSentinelUsername string `yaml:"redisSentinelUsername"`
+	RedisSentinelPassword string `yaml:"redisSentinelPassword" secret:"true"`
+	RedisUsername         string `yaml:"redisUsername" env:"REDIS_USERNAME"`
+	RedisPassword         string `yaml:"redisPassword" env:"REDIS_PASSWORD" secret:"true"`
+	RedisDB               int    `yaml:"redisDB"`
+
+	RedisTLS                   bool   `yaml:"redisTLS"`
+	RedisTLSCAFile             string `yaml:"redisCAFile"`
+	RedisTLSCertFile           string `yaml:"redisCertFile"`
+	RedisTLSKeyFile            string `yaml:"redisKeyFile"`
+	RedisTLSServerName         string `yaml:"redisTLSServerName"`
+	RedisTLSInsecureSkipVerify bool   `yaml:"redisTLSInsecureSkipVerify"`
+
+	// zero pool and timeout values are the go-redis defaults, timeouts are in seconds
+	RedisPoolSize     int `yaml:"redisPoolSize"`
+	RedisMinIdleConns int `yaml:"redisMinIdleConns"`
+	RedisPoolTimeOut  int `yaml:"redisPoolTimeOut"`
+	RedisDialTimeOut  int `yaml:"redisDialTimeOut"`
+	RedisReadTimeOut  int `yaml:"redisReadTimeOut"`
+	RedisWriteTimeOut int `yaml:"redisWriteTimeOut"`
+
+	RedisConnectAttempts int `yaml:"redisConnectAttempts"`
+	RedisConnectMaxDelay int `yaml:"redisConnectMaxDelay"`
+
+	// Proxies is the comma separated list of host:port, credentials may be in it
+	Proxies string `yaml:"proxies" env:"PROXIES" secret:"true"`
+
+	LogLevel string `yaml:"logLevel" reload:"true"`
+
 	StartDateScrapping  time.Time `yaml:"startDateScrapping"`
 	ProxyRecoverTimeOut int       `yaml:"proxyRecoverTimeOut"`
 	RedisChanelName     string    `yaml:"redisChanelName"`
 	PartitionsCount     int       `yaml:"partitionsCount"`
+
+	// UserAgent is the colly one if empty
+	UserAgent          string `yaml:"userAgent" reload:"true"`
+	RequestDelay       int    `yaml:"requestDelay" reload:"true"`
+	RequestRandomDelay int    `yaml:"requestRandomDelay" reload:"true"`
+	RequestTimeOut     int    `yaml:"requestTimeOut" reload:"true"`
+
+	ProxyRequestsPerSecond float64 `yaml:"proxyRequestsPerSecond" reload:"true"`
+	ProxyBurst             int     `yaml:"proxyBurst" reload:"true"`
+	DefaultRetryAfter      int     `yaml:"defaultRetryAfter" reload:"true"`
+
+	RetryMaxAttempts int    `yaml:"retryMaxAttempts" reload:"true"`
+	RetryBaseDelay   int    `yaml:"retryBaseDelay" reload:"true"`
+	RetryMaxDelay    int    `yaml:"retryMaxDelay" reload:"true"`
+	DeadLetterKey    string `yaml:"deadLetterKey"`
+
+	MinConcurrency      int `yaml:"minConcurrency" reload:"true"`
+	MaxConcurrency      int `yaml:"maxConcurrency" reload:"true"`
+	ConcurrencyPerProxy int `yaml:"concurrencyPerProxy" reload:"true"`
+	// BackfillShare is the share of the concurrency limit for past days while today is scraped
+	BackfillShare float64 `yaml:"backfillShare" reload:"true"`
+
+	BreakerWindow      int     `yaml:"breakerWindow" reload:"true"`
+	BreakerMinRequests int     `yaml:"breakerMinRequests" reload:"true"`
+	BreakerFailureRate float64 `yaml:"breakerFailureRate" reload:"true"`
+	BreakerOpenTimeout int     `yaml:"breakerOpenTimeout" reload:"true"`
+
+	ProxyStatsKey string        `yaml:"proxyStatsKey"`
+	ProxyQuotas   []proxy.Quota `yaml:"proxyQuotas"`
+
+	StickySessions bool `yaml:"stickySessions"`
+
+	AdminHost string `yaml:"adminHost"`
+	AdminPort int    `yaml:"adminPort"`
+	// the probes and the metrics are served apart from the admin API, which has no authentication
+	ProbesHost string `yaml:"probesHost"`
+	ProbesPort int    `yaml:"probesPort"`
+
+	StallTimeOut int `yaml:"stallTimeOut"`
+
+	DrainTimeOut  int    `yaml:"drainTimeOut" reload:"true"`
+	CheckpointKey string `yaml:"checkpointKey"`
+
+	LeaseKey string `yaml:"leaseKey"`
+	LeaseTTL int    `yaml:"leaseTTL"`
+
+	Schedules []schedule.Config `yaml:"schedules"`
+
+	// raw article responses are written to ArchiveDir as WARC files rotated
+	// after ArchiveMaxSize megabytes or ArchiveMaxAge seconds
+	ArchiveEnabled bool   `yaml:"archiveEnabled"`
+	ArchiveDir     string `yaml:"archiveDir"`
+	ArchivePrefix  string `yaml:"archivePrefix"`
+	ArchiveMaxSize int    `yaml:"archiveMaxSize"`
+	ArchiveMaxAge  int    `yaml:"archiveMaxAge"`
+
+	// CassetteMode is record or replay, the responses are recorded to CassetteDir or served
+	// from it without the network. Empty is off.
+	CassetteMode string `yaml:"cassetteMode"`
+	CassetteDir  string `yaml:"cassetteDir"`
+
+	// the coverage of the article fields over QualityWindow seconds is checked every QualityCheckInterval
+	// seconds, the alerts go to the logs, the metrics and QualityWebhook if it is set
+	QualityWindow        int     `yaml:"qualityWindow"`
+	QualityCheckInterval int     `yaml:"qualityCheckInterval"`
+	QualityMinArticles   int     `yaml:"qualityMinArticles"`
+	QualityMinTitle      float64 `yaml:"qualityMinTitle"`
+	QualityMinBody       float64 `yaml:"qualityMinBody"`
+	QualityMinDate       float64 `yaml:"qualityMinDate"`
+	QualityMinBodyLength int     `yaml:"qualityMinBodyLength"`
+	QualitySamples       int     `yaml:"qualitySamples"`
+	QualityWebhook       string  `yaml:"qualityWebhook" secret:"true"`
+
+	// the fingerprints of the articles are kept for DedupWindow seconds, an article within
+	// DedupMaxDistance bits of an earlier one is its near duplicate
+	DedupEnabled     bool   `yaml:"dedupEnabled"`
+	DedupKey         string `yaml:"dedupKey"`
+	DedupWindow      int    `yaml:"dedupWindow"`
+	DedupMaxDistance int    `yaml:"dedupMaxDistance"`
+
+	// RevisionDelays is the comma separated seconds after the publication to fetch an article again
+	// and publish it as updated if it changed, no rechecks if empty. The due articles are claimed
+	// every RevisionCheckInterval seconds by RevisionBatch.
+	RevisionDelays        string `yaml:"revisionDelays"`
+	RevisionKey           string `yaml:"revisionKey"`
+	RevisionCheckInterval int    `yaml:"revisionCheckInterval"`
+	RevisionBatch         int    `yaml:"revisionBatch"`
 }
 
-func LoadConfig(filename string) (Config, error) {
-	var cfg Config
-	input, err := os.ReadFile(filename)
-	if err != nil {
-		return cfg, fmt.Errorf("can't read file: %w", err)
+func Default() Config {
+	return Config{
+		RedisMode:              redis.Single,
+		RedisHost:              "localhost",
+		RedisPort:              6379,
+		RedisConnectAttempts:   10,
+		RedisConnectMaxDelay:   30,
+		LogLevel:               "info",
+		StartDateScrapping:     time.Date(2022, 1, 1, 0, 0, 0, 0, time.FixedZone("", 4*60*60)),
+		ProxyRecoverTimeOut:    600,
+		RedisChanelName:        "scrapper",
+		PartitionsCount:        15,
+		RequestTimeOut:         10,
+		ProxyRequestsPerSecond: 2,
+		ProxyBurst:             5,
+		DefaultRetryAfter:      30,
+		RetryMaxAttempts:       5,
+		RetryBaseDelay:         1,
+		RetryMaxDelay:          60,
+		DeadLetterKey:          "scrapper_dead_letter",
+		MinConcurrency:         2,
+		MaxConcurrency:         64,
+		ConcurrencyPerProxy:    2,
+		BackfillShare:          0.5,
+		BreakerWindow:          60,
+		BreakerMinRequests:     20,
+		BreakerFailureRate:     0.5,
+		BreakerOpenTimeout:     120,
+		ProxyStatsKey:          "scrapper_proxy_stats",
+		StickySessions:         true,
+		AdminHost:              "127.0.0.1",
+		AdminPort:              8081,
+		ProbesHost:             "0.0.0.0",
+		ProbesPort:             8082,
+		StallTimeOut:           900,
+		DrainTimeOut:           60,
+		CheckpointKey:          "scrapper_checkpoint",
+		LeaseKey:               "scrapper_lease",
+		LeaseTTL:               30,
+		ArchiveEnabled:         true,
+		ArchiveDir:             "./archive",
+		ArchivePrefix:          "scrapper",
+		ArchiveMaxSize:         1024,
+		ArchiveMaxAge:          86400,
+		CassetteDir:            "./cassette",
+		QualityWindow:          3600,
+		QualityCheckInterval:   60,
+		QualityMinArticles:     20,
+		QualityMinTitle:        0.95,
+		QualityMinBody:         0.9,
+		QualityMinDate:         0.95,
+		QualityMinBodyLength:   200,
+		QualitySamples:         5,
+		DedupEnabled:           true,
+		DedupKey:               "scrapper_dedup",
+		DedupWindow:            7 * 24 * 3600,
+		DedupMaxDistance:       6,
+		RevisionDelays:         "3600,86400",
+		RevisionKey:            "scrapper_revision",
+		RevisionCheckInterval:  60,
+		RevisionBatch:          50,
 	}
+}
 
-	if err := yaml.Unmarshal(input, &cfg); err != nil {
-		return cfg, fmt.Errorf("can't unmarshal config: %w", err)
+// Load builds the config from the command line arguments without the program name
+// and returns the arguments left after the flags. The proxies may be empty only in the replay
+// mode of the cassette or when the first of the arguments is one of noProxyCommands,
+// the commands that don't fetch through the proxies.
+func Load(args []string, noProxyCommands ...string) (Config, []string, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("web-scraper", flag.ContinueOnError)
+	path := fs.String("config", DefaultPath, "path to the YAML config")
+	flags := newFlags(fs, cfg)
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, fmt.Errorf("can't parse flags: %w", err)
+	}
+
+	var pathSet bool
+	fs.Visit(func(f *flag.Flag) {
+		pathSet = pathSet || f.Name == "config"
+	})
+	input, err := os.ReadFile(*path)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && !pathSet:
+		// the default file is optional
+	case err != nil:
+		return cfg, nil, fmt.Errorf("can't read file: %w", err)
+	default:
+		if err := yaml.Unmarshal(input, &cfg); err != nil {
+			return cfg, nil, fmt.Errorf("can't unmarshal config: %w", err)
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, nil, err
+	}
+	if err := flags.apply(fs, &cfg); err != nil {
+		return cfg, nil, err
+	}
+
+	args = fs.Args()
+	// the pages are replayed from the cassette without the proxies
+	proxies := cfg.CassetteMode != cassette.Replay && (len(args) == 0 || !slices.Contains(noProxyCommands, args[0]))
+	return cfg, args, cfg.validate(proxies)
+}
+
+// Schedule returns the schedule of the source, the default one if it is not in the config.
+func (cfg Config) Schedule(source string) schedule.Config {
+	for _, sc := range cfg.Schedules {
+		if sc.Source == source {
+			return sc
+		}
+	}
+	return schedule.Default(source)
+}
+
+// Revisions returns RevisionDelays parsed, in increasing order.
+func (cfg Config) Revisions() ([]time.Duration, error) {
+	var res []time.Duration
+	for _, v := range strings.Split(cfg.RevisionDelays, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("RevisionDelays=%s must be positive seconds", cfg.RevisionDelays)
+		}
+		d := time.Duration(n) * time.Second
+		if len(res) > 0 && d <= res[len(res)-1] {
+			return nil, fmt.Errorf("RevisionDelays=%s must increase", cfg.RevisionDelays)
+		}
+		res = append(res, d)
+	}
+	return res, nil
+}
+
+func (cfg Config) Validate() error {
+	return cfg.validate(cfg.CassetteMode != cassette.Replay)
+}
+
+func (cfg Config) validate(proxies bool) error {
+	switch cfg.RedisMode {
+	case redis.Single:
+		if cfg.RedisHost == "" {
+			return fmt.Errorf("RedisHost is empty")
+		}
+		if cfg.RedisPort <= 0 || cfg.RedisPort > 65535 {
+			return fmt.Errorf("RedisPort=%d must be in [1, 65535]", cfg.RedisPort)
+		}
+	case redis.Sentinel:
+		if cfg.RedisAddrs == "" {
+			return fmt.Errorf("RedisAddrs is empty, sentinels are needed in the %s mode", cfg.RedisMode)
+		}
+		if cfg.RedisMasterName == "" {
+			return fmt.Errorf("RedisMasterName is empty, it is needed in the %s mode", cfg.RedisMode)
+		}
+	case redis.Cluster:
+		if cfg.RedisAddrs == "" {
+			return fmt.Errorf("RedisAddrs is empty, seed nodes are needed in the %s mode", cfg.RedisMode)
+		}
+		if cfg.RedisDB != 0 {
+			return fmt.Errorf("RedisDB=%d must be 0 in the %s mode", cfg.RedisDB, cfg.RedisMode)
+		}
+	default:
+		return fmt.Errorf("RedisMode=%s must be %s, %s or %s", cfg.RedisMode, redis.Single, redis.Sentinel, redis.Cluster)
+	}
+
+	if cfg.RedisDB < 0 {
+		return fmt.Errorf("RedisDB=%d can't be < 0", cfg.RedisDB)
+	}
+
+	if !cfg.RedisTLS && (cfg.RedisTLSCAFile != "" || cfg.RedisTLSCertFile != "" || cfg.RedisTLSKeyFile != "") {
+		return fmt.Errorf("RedisTLS is off, but the TLS files are set")
+	}
+
+	if (cfg.RedisTLSCertFile == "") != (cfg.RedisTLSKeyFile == "") {
+		return fmt.Errorf("RedisTLSCertFile and RedisTLSKeyFile must be set together")
+	}
+
+	for name, v := range map[string]int{
+		"RedisPoolSize":     cfg.RedisPoolSize,
+		"RedisMinIdleConns": cfg.RedisMinIdleConns,
+		"RedisPoolTimeOut":  cfg.RedisPoolTimeOut,
+		"RedisDialTimeOut":  cfg.RedisDialTimeOut,
+		"RedisReadTimeOut":  cfg.RedisReadTimeOut,
+		"RedisWriteTimeOut": cfg.RedisWriteTimeOut,
+	} {
+		if v < 0 {
+			return fmt.Errorf("%s=%d can't be < 0", name, v)
+		}
+	}
+
+	if cfg.RedisConnectAttempts <= 0 {
+		return fmt.Errorf("RedisConnectAttempts=%d can't be <= 0", cfg.RedisConnectAttempts)
+	}
+
+	if cfg.RedisConnectMaxDelay <= 0 {
+		return fmt.Errorf("RedisConnectMaxDelay=%d can't be <= 0", cfg.RedisConnectMaxDelay)
+	}
+
+	if proxies && cfg.Proxies == "" {
+		return fmt.Errorf("Proxies is empty")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		return fmt.Errorf("LogLevel=%s must be debug, info, warn or error", cfg.LogLevel)
 	}
 
 	if cfg.StartDateScrapping.After(time.Now()) {
-		return cfg, fmt.Errorf("StartDateScrapping=%s can't be greater than now=%s", cfg.StartDateScrapping, time.Now())
+		return fmt.Errorf("StartDateScrapping=%s can't be greater than now=%s", cfg.StartDateScrapping, time.Now())
 	}
 
 	if cfg.ProxyRecoverTimeOut <= 0 {
-		return cfg, fmt.Errorf("ProxyRecoverTimeOut=%d can't be <= 0", cfg.ProxyRecoverTimeOut)
+		return fmt.Errorf("ProxyRecoverTimeOut=%d can't be <= 0", cfg.ProxyRecoverTimeOut)
 	}
 
 	if cfg.RedisChanelName == "" {
-		return cfg, fmt.Errorf("RedisChanelName if empty")
+		return fmt.Errorf("RedisChanelName is empty")
 	}
 
 	if cfg.PartitionsCount <= 0 {
-		return cfg, fmt.Errorf("PartitionsCount=%d can't be <= 0", cfg.PartitionsCount)
+		return fmt.Errorf("PartitionsCount=%d can't be <= 0", cfg.PartitionsCount)
+	}
+
+	if cfg.RequestDelay < 0 {
+		return fmt.Errorf("RequestDelay=%d can't be < 0", cfg.RequestDelay)
+	}
+
+	if cfg.RequestRandomDelay < 0 {
+		return fmt.Errorf("RequestRandomDelay=%d can't be < 0", cfg.RequestRandomDelay)
+	}
+
+	if cfg.RequestTimeOut <= 0 {
+		return fmt.Errorf("RequestTimeOut=%d can't be <= 0", cfg.RequestTimeOut)
+	}
+
+	if cfg.ProxyRequestsPerSecond <= 0 {
+		return fmt.Errorf("ProxyRequestsPerSecond=%f can't be <= 0", cfg.ProxyRequestsPerSecond)
+	}
+
+	if cfg.ProxyBurst <= 0 {
+		return fmt.Errorf("ProxyBurst=%d can't be <= 0", cfg.ProxyBurst)
+	}
+
+	if cfg.DefaultRetryAfter <= 0 {
+		return fmt.Errorf("DefaultRetryAfter=%d can't be <= 0", cfg.DefaultRetryAfter)
+	}
+
+	if cfg.RetryMaxAttempts <= 0 {
+		return fmt.Errorf("RetryMaxAttempts=%d can't be <= 0", cfg.RetryMaxAttempts)
+	}
+
+	if cfg.RetryBaseDelay <= 0 {
+		return fmt.Errorf("RetryBaseDelay=%d can't be <= 0", cfg.RetryBaseDelay)
+	}
+
+	if cfg.RetryMaxDelay < cfg.RetryBaseDelay {
+		return fmt.Errorf("RetryMaxDelay=%d can't be < RetryBaseDelay=%d", cfg.RetryMaxDelay, cfg.RetryBaseDelay)
+	}
+
+	if cfg.DeadLetterKey == "" {
+		return fmt.Errorf("DeadLetterKey is empty")
+	}
+
+	if cfg.MinConcurrency <= 0 {
+		return fmt.Errorf("MinConcurrency=%d can't be <= 0", cfg.MinConcurrency)
+	}
+
+	if cfg.MaxConcurrency < cfg.MinConcurrency {
+		return fmt.Errorf("MaxConcurrency=%d can't be < MinConcurrency=%d", cfg.MaxConcurrency, cfg.MinConcurrency)
+	}
+
+	if cfg.ConcurrencyPerProxy <= 0 {
+		return fmt.Errorf("ConcurrencyPerProxy=%d can't be <= 0", cfg.ConcurrencyPerProxy)
+	}
+
+	if cfg.BackfillShare <= 0 || cfg.BackfillShare > 1 {
+		return fmt.Errorf("BackfillShare=%f must be in (0, 1]", cfg.BackfillShare)
+	}
+
+	if cfg.BreakerWindow <= 0 {
+		return fmt.Errorf("BreakerWindow=%d can't be <= 0", cfg.BreakerWindow)
+	}
+
+	if cfg.BreakerMinRequests <= 0 {
+		return fmt.Errorf("BreakerMinRequests=%d can't be <= 0", cfg.BreakerMinRequests)
+	}
+
+	if cfg.BreakerFailureRate <= 0 || cfg.BreakerFailureRate > 1 {
+		return fmt.Errorf("BreakerFailureRate=%f must be in (0, 1]", cfg.BreakerFailureRate)
+	}
+
+	if cfg.BreakerOpenTimeout <= 0 {
+		return fmt.Errorf("BreakerOpenTimeout=%d can't be <= 0", cfg.BreakerOpenTimeout)
+	}
+
+	if cfg.ProxyStatsKey == "" {
+		return fmt.Errorf("ProxyStatsKey is empty")
+	}
+
+	for i, q := range cfg.ProxyQuotas {
+		if q.Host == "" {
+			return fmt.Errorf("ProxyQuotas[%d].Host is empty", i)
+		}
+		if q.Period != proxy.Daily && q.Period != proxy.Monthly {
+			return fmt.Errorf("ProxyQuotas[%d].Period=%s must be %s or %s", i, q.Period, proxy.Daily, proxy.Monthly)
+		}
+		if q.Requests < 0 || q.Bytes < 0 || q.Requests == 0 && q.Bytes == 0 {
+			return fmt.Errorf("ProxyQuotas[%d] must limit requests or bytes with positive values", i)
+		}
+	}
+
+	if cfg.AdminPort <= 0 || cfg.AdminPort > 65535 {
+		return fmt.Errorf("AdminPort=%d must be in [1, 65535]", cfg.AdminPort)
+	}
+	if cfg.ProbesPort <= 0 || cfg.ProbesPort > 65535 {
+		return fmt.Errorf("ProbesPort=%d must be in [1, 65535]", cfg.ProbesPort)
+	}
+	if cfg.ProbesPort == cfg.AdminPort {
+		return fmt.Errorf("ProbesPort=%d can't be the same as AdminPort", cfg.ProbesPort)
+	}
+
+	if cfg.StallTimeOut <= 0 {
+		return fmt.Errorf("StallTimeOut=%d can't be <= 0", cfg.StallTimeOut)
+	}
+
+	if cfg.DrainTimeOut <= 0 {
+		return fmt.Errorf("DrainTimeOut=%d can't be <= 0", cfg.DrainTimeOut)
+	}
+
+	if cfg.CheckpointKey == "" {
+		return fmt.Errorf("CheckpointKey is empty")
+	}
+
+	if cfg.LeaseKey == "" {
+		return fmt.Errorf("LeaseKey is empty")
+	}
+
+	if cfg.LeaseTTL < 3 {
+		return fmt.Errorf("LeaseTTL=%d can't be < 3, the lease is renewed every third of it", cfg.LeaseTTL)
+	}
+
+	if cfg.ArchiveEnabled {
+		if cfg.ArchiveDir == "" {
+			return fmt.Errorf("ArchiveDir is empty")
+		}
+		if cfg.ArchivePrefix == "" {
+			return fmt.Errorf("ArchivePrefix is empty")
+		}
+		if cfg.ArchiveMaxSize <= 0 {
+			return fmt.Errorf("ArchiveMaxSize=%d can't be <= 0", cfg.ArchiveMaxSize)
+		}
+		if cfg.ArchiveMaxAge <= 0 {
+			return fmt.Errorf("ArchiveMaxAge=%d can't be <= 0", cfg.ArchiveMaxAge)
+		}
+	}
+
+	switch cfg.CassetteMode {
+	case "", cassette.Record, cassette.Replay:
+	default:
+		return fmt.Errorf("CassetteMode=%s must be empty, %s or %s", cfg.CassetteMode, cassette.Record, cassette.Replay)
+	}
+	if cfg.CassetteMode != "" && cfg.CassetteDir == "" {
+		return fmt.Errorf("CassetteDir is empty")
+	}
+
+	if cfg.QualityWindow <= 0 {
+		return fmt.Errorf("QualityWindow=%d can't be <= 0", cfg.QualityWindow)
+	}
+	if cfg.QualityCheckInterval <= 0 {
+		return fmt.Errorf("QualityCheckInterval=%d can't be <= 0", cfg.QualityCheckInterval)
+	}
+	if cfg.QualityMinArticles <= 0 {
+		return fmt.Errorf("QualityMinArticles=%d can't be <= 0", cfg.QualityMinArticles)
+	}
+	for name, v := range map[string]float64{
+		"QualityMinTitle": cfg.QualityMinTitle,
+		"QualityMinBody":  cfg.QualityMinBody,
+		"QualityMinDate":  cfg.QualityMinDate,
+	} {
+		if v < 0 || v > 1 {
+			return fmt.Errorf("%s=%v must be in [0, 1]", name, v)
+		}
+	}
+	if cfg.QualityMinBodyLength < 0 {
+		return fmt.Errorf("QualityMinBodyLength=%d can't be < 0", cfg.QualityMinBodyLength)
+	}
+	if cfg.QualitySamples < 0 {
+		return fmt.Errorf("QualitySamples=%d can't be < 0", cfg.QualitySamples)
+	}
+	if cfg.QualityWebhook != "" {
+		if u, err := url.Parse(cfg.QualityWebhook); err != nil || u.Host == "" {
+			return fmt.Errorf("QualityWebhook is not a url")
+		}
+	}
+
+	if cfg.DedupEnabled {
+		if cfg.DedupKey == "" {
+			return fmt.Errorf("DedupKey is empty")
+		}
+		if cfg.DedupWindow <= 0 {
+			return fmt.Errorf("DedupWindow=%d can't be <= 0", cfg.DedupWindow)
+		}
+		if cfg.DedupMaxDistance < 0 || cfg.DedupMaxDistance > dedup.MaxDistance {
+			return fmt.Errorf("DedupMaxDistance=%d must be in [0, %d]", cfg.DedupMaxDistance, dedup.MaxDistance)
+		}
+	}
+
+	if cfg.RevisionDelays != "" {
+		if _, err := cfg.Revisions(); err != nil {
+			return err
+		}
+		if cfg.RevisionKey == "" {
+			return fmt.Errorf("RevisionKey is empty")
+		}
+		if cfg.RevisionCheckInterval <= 0 {
+			return fmt.Errorf("RevisionCheckInterval=%d can't be <= 0", cfg.RevisionCheckInterval)
+		}
+		if cfg.RevisionBatch <= 0 {
+			return fmt.Errorf("RevisionBatch=%d can't be <= 0", cfg.RevisionBatch)
+		}
+	}
+
+	sources := make(map[string]struct{}, len(cfg.Schedules))
+	for i, sc := range cfg.Schedules {
+		if sc.Source == "" {
+			return fmt.Errorf("Schedules[%d].Source is empty", i)
+		}
+		if _, ok := sources[sc.Source]; ok {
+			return fmt.Errorf("Schedules[%d].Source=%s is repeated", i, sc.Source)
+		}
+		sources[sc.Source] = struct{}{}
+		if _, err := schedule.New(sc); err != nil {
+			return fmt.Errorf("Schedules[%d]: %w", i, err)
+		}
 	}
 
-	return cfg, nil
+	return nil
 }
@@ -0,0 +1,219 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/STTM-NSU/web-scrapper/internal/cassette"
+	"github.com/STTM-NSU/web-scrapper/internal/db/redis"
+	"github.com/STTM-NSU/web-scrapper/internal/schedule"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadLayers(t *testing.T) {
+	path := writeConfig(t, `
+proxies: file:1
+redisPort: 6380
+requestTimeOut: 20
+retryMaxAttempts: 7
+`)
+	tests := []struct {
+		name  string
+		env   map[string]string
+		args  []string
+		check func(t *testing.T, cfg Config)
+	}{
+		{
+			name: "file over defaults",
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Proxies != "file:1" || cfg.RedisPort != 6380 || cfg.RequestTimeOut != 20 {
+					t.Errorf("got %s %d %d, want the file values", cfg.Proxies, cfg.RedisPort, cfg.RequestTimeOut)
+				}
+				if cfg.RedisHost != Default().RedisHost {
+					t.Errorf("RedisHost = %s, want the default", cfg.RedisHost)
+				}
+			},
+		},
+		{
+			name: "env over file",
+			env:  map[string]string{"REDIS_PORT": "6381", "SCRAPPER_REQUEST_TIME_OUT": "30", "SCRAPPER_REDIS_DB": "2"},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.RedisPort != 6381 || cfg.RequestTimeOut != 30 || cfg.RedisDB != 2 {
+					t.Errorf("got %d %d %d, want the env values", cfg.RedisPort, cfg.RequestTimeOut, cfg.RedisDB)
+				}
+			},
+		},
+		{
+			name: "flags over env",
+			env:  map[string]string{"SCRAPPER_REQUEST_TIME_OUT": "30", "SCRAPPER_ARCHIVE_ENABLED": "true"},
+			args: []string{"--request-time-out", "40", "--archive-enabled=false", "--retry-max-attempts=9"},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.RequestTimeOut != 40 || cfg.ArchiveEnabled || cfg.RetryMaxAttempts != 9 {
+					t.Errorf("got %d %t %d, want the flag values", cfg.RequestTimeOut, cfg.ArchiveEnabled, cfg.RetryMaxAttempts)
+				}
+			},
+		},
+		{
+			name: "date flag",
+			args: []string{"--start-date-scrapping", "2023-05-01"},
+			check: func(t *testing.T, cfg Config) {
+				if got := cfg.StartDateScrapping.Format("2006-01-02"); got != "2023-05-01" {
+					t.Errorf("StartDateScrapping = %s, want 2023-05-01", got)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg, _, err := Load(append([]string{"--config", path}, tt.args...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	noProxies := writeConfig(t, "redisPort: 6380\n")
+	tests := []struct {
+		name     string
+		env      map[string]string
+		args     []string
+		wantArgs []string
+		wantErr  bool
+	}{
+		{"no proxies", nil, []string{"--config", noProxies}, []string{}, true},
+		{"proxies from flag", nil, []string{"--config", noProxies, "--proxies", "flag:1", "extract"}, []string{"extract"}, false},
+		{"no proxy command", nil, []string{"--config", noProxies, "extract", "https://www.rbc.ru"}, []string{"extract", "https://www.rbc.ru"}, false},
+		{"other command", nil, []string{"--config", noProxies, "redrive"}, []string{"redrive"}, true},
+		{"replay", nil, []string{"--config", noProxies, "--cassette-mode", cassette.Replay, "dry-run"}, []string{"dry-run"}, false},
+		{"record", nil, []string{"--config", noProxies, "--cassette-mode", cassette.Record}, []string{}, true},
+		{"missing file", nil, []string{"--config", filepath.Join(t.TempDir(), "none.yaml"), "extract"}, nil, true},
+		{"bad env", map[string]string{"REDIS_PORT": "port"}, []string{"--config", noProxies, "extract"}, nil, true},
+		{"bad flag", nil, []string{"--config", noProxies, "--redis-port", "port", "extract"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, args, err := Load(tt.args, "extract", "reparse")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantArgs != nil && !slices.Equal(args, tt.wantArgs) {
+				t.Errorf("Load() args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *Config)
+		wantErr bool
+	}{
+		{"default", func(cfg *Config) {}, false},
+		{"no proxies", func(cfg *Config) { cfg.Proxies = "" }, true},
+		{"no proxies in replay", func(cfg *Config) { cfg.Proxies, cfg.CassetteMode = "", cassette.Replay }, false},
+		{"bad cassette mode", func(cfg *Config) { cfg.CassetteMode = "rewind" }, true},
+		{"no cassette dir", func(cfg *Config) { cfg.CassetteMode, cfg.CassetteDir = cassette.Record, "" }, true},
+		{"bad redis mode", func(cfg *Config) { cfg.RedisMode = "ring" }, true},
+		{"sentinel without master", func(cfg *Config) { cfg.RedisMode, cfg.RedisAddrs = redis.Sentinel, "a:26379" }, true},
+		{"cluster with db", func(cfg *Config) { cfg.RedisMode, cfg.RedisAddrs, cfg.RedisDB = redis.Cluster, "a:6379", 1 }, true},
+		{"tls files without tls", func(cfg *Config) { cfg.RedisTLSCAFile = "ca.pem" }, true},
+		{"cert without key", func(cfg *Config) { cfg.RedisTLS, cfg.RedisTLSCertFile = true, "cert.pem" }, true},
+		{"bad log level", func(cfg *Config) { cfg.LogLevel = "verbose" }, true},
+		{"max delay below base", func(cfg *Config) { cfg.RetryBaseDelay, cfg.RetryMaxDelay = 10, 5 }, true},
+		{"max concurrency below min", func(cfg *Config) { cfg.MinConcurrency, cfg.MaxConcurrency = 10, 5 }, true},
+		{"backfill share", func(cfg *Config) { cfg.BackfillShare = 1.5 }, true},
+		{"same ports", func(cfg *Config) { cfg.ProbesPort = cfg.AdminPort }, true},
+		{"short lease", func(cfg *Config) { cfg.LeaseTTL = 2 }, true},
+		{"archive without dir", func(cfg *Config) { cfg.ArchiveDir = "" }, true},
+		{"archive off without dir", func(cfg *Config) { cfg.ArchiveEnabled, cfg.ArchiveDir = false, "" }, false},
+		{"bad webhook", func(cfg *Config) { cfg.QualityWebhook = "hooks" }, true},
+		{"dedup distance over bands", func(cfg *Config) { cfg.DedupMaxDistance = 8 }, true},
+		{"decreasing revisions", func(cfg *Config) { cfg.RevisionDelays = "86400,3600" }, true},
+		{"no revisions", func(cfg *Config) { cfg.RevisionDelays, cfg.RevisionKey = "", "" }, false},
+		{"schedule", func(cfg *Config) {
+			cfg.Schedules = []schedule.Config{{Source: "rbc", LivePollInterval: 60, BackfillWindows: []string{"22:00-06:00"}}}
+		}, false},
+		{"repeated schedule", func(cfg *Config) {
+			cfg.Schedules = []schedule.Config{schedule.Default("rbc"), schedule.Default("rbc")}
+		}, true},
+		{"bad schedule", func(cfg *Config) {
+			cfg.Schedules = []schedule.Config{{Source: "rbc", LivePollInterval: 60, QuietHours: []string{"night"}}}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Proxies = "proxy:1"
+			tt.modify(&cfg)
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"redisPort", "redis-port"},
+		{"redisDB", "redis-db"},
+		{"redisTLSServerName", "redis-tls-server-name"},
+		{"requestTimeOut", "request-time-out"},
+		{"leaseTTL", "lease-ttl"},
+	}
+	for _, tt := range tests {
+		if got := split(tt.name, '-'); got != tt.want {
+			t.Errorf("split(%s) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	cur := Default()
+	next := cur
+	next.LogLevel = "debug"
+	next.RedisPort = 6380
+
+	res, applied, restart := Reload(cur, next)
+	if res.LogLevel != "debug" || res.RedisPort != cur.RedisPort {
+		t.Errorf("Reload() = %s %d, want debug %d", res.LogLevel, res.RedisPort, cur.RedisPort)
+	}
+	if !slices.Equal(applied, []string{"logLevel"}) || !slices.Equal(restart, []string{"redisPort"}) {
+		t.Errorf("Reload() applied %v, restart %v", applied, restart)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.Proxies = "user:password@proxy:1"
+	res := cfg.Redacted()
+	if res["proxies"] != redacted {
+		t.Errorf("proxies = %s, want %s", res["proxies"], redacted)
+	}
+	// empty secrets are shown, so a missing one is seen in the logs
+	if res["redisPassword"] != "" {
+		t.Errorf("redisPassword = %s, want empty", res["redisPassword"])
+	}
+}
@@ -0,0 +1,224 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	envPrefix = "SCRAPPER_"
+	redacted  = "***"
+)
+
+type field struct {
+	name   string
+	index  int
+	env    string
+	flag   string
+	secret bool
+	reload bool
+}
+
+// fields returns the fields of the config that are set by the env vars and the flags.
+func fields() []field {
+	t := reflect.TypeFor[Config]()
+	res := make([]field, 0, t.NumField())
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name := f.Tag.Get("yaml")
+		env := f.Tag.Get("env")
+		if env == "" {
+			env = envPrefix + strings.ToUpper(split(name, '_'))
+		}
+		res = append(res, field{
+			name:   name,
+			index:  i,
+			env:    env,
+			flag:   split(name, '-'),
+			secret: f.Tag.Get("secret") == "true",
+			reload: f.Tag.Get("reload") == "true",
+		})
+	}
+	return res
+}
+
+// split turns camelCase into lower words joined by sep, an acronym is one word: redisDB is redis_db.
+func split(name string, sep rune) string {
+	rs := []rune(name)
+	var b strings.Builder
+	for i, r := range rs {
+		if unicode.IsUpper(r) && i > 0 {
+			lowerBefore := !unicode.IsUpper(rs[i-1])
+			lowerAfter := i+1 < len(rs) && unicode.IsLower(rs[i+1])
+			if lowerBefore || lowerAfter {
+				b.WriteRune(sep)
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+func settable(v reflect.Value) bool {
+	switch v.Interface().(type) {
+	case string, int, float64, bool, time.Time:
+		return true
+	default:
+		return false
+	}
+}
+
+func set(v reflect.Value, raw string) error {
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(raw)
+	case int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case time.Time:
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, raw)
+		}
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func applyEnv(cfg *Config) error {
+	v := reflect.ValueOf(cfg).Elem()
+	for _, f := range fields() {
+		raw, ok := os.LookupEnv(f.env)
+		if !ok || !settable(v.Field(f.index)) {
+			continue
+		}
+		if err := set(v.Field(f.index), raw); err != nil {
+			return fmt.Errorf("bad env %s=%q: %w", f.env, raw, err)
+		}
+	}
+	return nil
+}
+
+// flagValue keeps the raw value of the flag until the file and the env are applied.
+type flagValue struct {
+	raw    string
+	isBool bool
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.raw
+}
+
+func (f *flagValue) Set(raw string) error {
+	f.raw = raw
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+type flags map[string]*flagValue
+
+func newFlags(fs *flag.FlagSet, defaults Config) flags {
+	res := make(flags)
+	v := reflect.ValueOf(defaults)
+	for _, f := range fields() {
+		if !settable(v.Field(f.index)) {
+			continue
+		}
+		fv := &flagValue{raw: format(v.Field(f.index), f.secret), isBool: v.Field(f.index).Kind() == reflect.Bool}
+		fs.Var(fv, f.flag, fmt.Sprintf("%s, env %s", f.name, f.env))
+		res[f.flag] = fv
+	}
+	return res
+}
+
+func (fl flags) apply(fs *flag.FlagSet, cfg *Config) error {
+	v := reflect.ValueOf(cfg).Elem()
+	var err error
+	fs.Visit(func(ff *flag.Flag) {
+		if err != nil {
+			return
+		}
+		for _, f := range fields() {
+			if f.flag != ff.Name {
+				continue
+			}
+			if setErr := set(v.Field(f.index), fl[f.flag].raw); setErr != nil {
+				err = fmt.Errorf("bad flag --%s=%q: %w", f.flag, fl[f.flag].raw, setErr)
+			}
+		}
+	})
+	return err
+}
+
+func format(v reflect.Value, secret bool) string {
+	if secret && !v.IsZero() {
+		return redacted
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// Redacted returns the config as yaml name to value with the secrets hidden, to be logged.
+func (cfg Config) Redacted() map[string]string {
+	v := reflect.ValueOf(cfg)
+	res := make(map[string]string, v.NumField())
+	for _, f := range fields() {
+		res[f.name] = format(v.Field(f.index), f.secret)
+	}
+	return res
+}
+
+// Reload returns cur with the fields that can be changed at runtime taken from next,
+// and the names of the changed fields that are applied and of the ones that need a restart.
+func Reload(cur, next Config) (Config, []string, []string) {
+	res := cur
+	cv, nv, rv := reflect.ValueOf(cur), reflect.ValueOf(next), reflect.ValueOf(&res).Elem()
+
+	var applied, restart []string
+	for _, f := range fields() {
+		if reflect.DeepEqual(cv.Field(f.index).Interface(), nv.Field(f.index).Interface()) {
+			continue
+		}
+		if !f.reload {
+			restart = append(restart, f.name)
+			continue
+		}
+		rv.Field(f.index).Set(nv.Field(f.index))
+		applied = append(applied, f.name)
+	}
+	return res, applied, restart
+}
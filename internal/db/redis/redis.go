@@ -2,22 +2,178 @@ package redis
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"log/slog"
+	"os"
+	"time"
 
 	"github.com/redis/go-redis/v9"
+
+	"github.com/STTM-NSU/web-scrapper/internal/retry"
+)
+
+const (
+	Single   = "single"
+	Sentinel = "sentinel"
+	Cluster  = "cluster"
 )
 
+type TLSConfig struct {
+	Enabled            bool
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// Config of the connection. Host and Port are used in the single mode, Addrs are the
+// sentinels in the sentinel mode and the seed nodes in the cluster one.
+// Zero pool and timeout values are the go-redis defaults.
 type Config struct {
-	Host     string
-	Port     string
-	Password string
+	Mode             string
+	Host             string
+	Port             int
+	Addrs            []string
+	MasterName       string
+	SentinelUsername string
+	SentinelPassword string
+	Username         string
+	Password         string
+	DB               int
+	TLS              TLSConfig
+
+	PoolSize     int
+	MinIdleConns int
+	PoolTimeout  time.Duration
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// Connect is the retry policy of the first ping
+	Connect retry.Policy
 }
 
-func Connect(ctx context.Context, cfg Config) (*redis.Client, error) {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%s", cfg.Host, cfg.Port),
-		Password: cfg.Password,
-	})
-	status := rdb.Ping(ctx)
-	return rdb, status.Err()
+// Connect creates the client and pings Redis until it answers or the attempts of cfg.Connect run out,
+// so the scrapper waits for Redis that is still starting.
+func Connect(ctx context.Context, cfg Config, logger *slog.Logger) (redis.UniversalClient, error) {
+	rdb, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := rdb.Ping(ctx).Err()
+		if err == nil {
+			logger.Info("connected to redis", slog.String("mode", cfg.Mode), slog.Int("attempt", attempt))
+			return rdb, nil
+		}
+		if attempt >= cfg.Connect.MaxAttempts {
+			_ = rdb.Close()
+			return nil, fmt.Errorf("can't ping redis after %d attempts: %w", attempt, err)
+		}
+
+		wait := cfg.Connect.Backoff(attempt)
+		logger.Warn("redis is not available",
+			slog.Int("attempt", attempt),
+			slog.Duration("wait", wait),
+			slog.String("error", err.Error()))
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			_ = rdb.Close()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func newClient(cfg Config) (redis.UniversalClient, error) {
+	tlsConfig, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Mode {
+	case Single, "":
+		return redis.NewClient(&redis.Options{
+			Addr:         fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			DB:           cfg.DB,
+			TLSConfig:    tlsConfig,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			PoolTimeout:  cfg.PoolTimeout,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		}), nil
+	case Sentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.MasterName,
+			SentinelAddrs:    cfg.Addrs,
+			SentinelUsername: cfg.SentinelUsername,
+			SentinelPassword: cfg.SentinelPassword,
+			Username:         cfg.Username,
+			Password:         cfg.Password,
+			DB:               cfg.DB,
+			TLSConfig:        tlsConfig,
+			PoolSize:         cfg.PoolSize,
+			MinIdleConns:     cfg.MinIdleConns,
+			PoolTimeout:      cfg.PoolTimeout,
+			DialTimeout:      cfg.DialTimeout,
+			ReadTimeout:      cfg.ReadTimeout,
+			WriteTimeout:     cfg.WriteTimeout,
+		}), nil
+	case Cluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        cfg.Addrs,
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			TLSConfig:    tlsConfig,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			PoolTimeout:  cfg.PoolTimeout,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown redis mode %s", cfg.Mode)
+	}
+}
+
+func newTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	res := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		ca, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read redis CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates in redis CA %s", cfg.CAFile)
+		}
+		res.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't load redis client certificate: %w", err)
+		}
+		res.Certificates = []tls.Certificate{cert}
+	}
+	return res, nil
 }
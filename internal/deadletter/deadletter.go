@@ -0,0 +1,84 @@
+package deadletter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+)
+
+// Extraction is the class of the entries whose page was fetched but had no complete article on it.
+const Extraction = "extraction"
+
+type Entry struct {
+	Url      string    `json:"url"`
+	Source   string    `json:"source"`
+	Day      string    `json:"day"`
+	Reason   string    `json:"reason"`
+	Class    string    `json:"class"`
+	Status   int       `json:"status"`
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// Queue is a Redis list of URLs that could not be fetched within the retry budget.
+type Queue struct {
+	rdb redis.UniversalClient
+	key string
+}
+
+func NewQueue(rdb redis.UniversalClient, key string) *Queue {
+	return &Queue{
+		rdb: rdb,
+		key: key,
+	}
+}
+
+func (q *Queue) Push(ctx context.Context, entry Entry) error {
+	data, err := sonic.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("can't marshal entry: %w", err)
+	}
+	if err := q.rdb.LPush(ctx, q.key, data).Err(); err != nil {
+		return fmt.Errorf("can't push entry: %w", err)
+	}
+	return nil
+}
+
+// Item is an entry as it is stored in the queue.
+type Item struct {
+	Entry
+	data string
+}
+
+// Range returns all the entries in the queue, the oldest first. They stay in the queue
+// until they are removed.
+func (q *Queue) Range(ctx context.Context) ([]Item, error) {
+	data, err := q.rdb.LRange(ctx, q.key, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("can't get entries: %w", err)
+	}
+	items := make([]Item, 0, len(data))
+	for i := len(data) - 1; i >= 0; i-- {
+		item := Item{data: data[i]}
+		if err := sonic.UnmarshalString(data[i], &item.Entry); err != nil {
+			return nil, fmt.Errorf("can't unmarshal entry: %w", err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Remove deletes the item returned by Range from the queue.
+func (q *Queue) Remove(ctx context.Context, item Item) error {
+	if err := q.rdb.LRem(ctx, q.key, 1, item.data).Err(); err != nil {
+		return fmt.Errorf("can't remove entry: %w", err)
+	}
+	return nil
+}
+
+func (q *Queue) Len(ctx context.Context) (int64, error) {
+	return q.rdb.LLen(ctx, q.key).Result()
+}
@@ -0,0 +1,183 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+)
+
+// the fingerprint is split into bands, fingerprints that differ
+// in fewer bits than there are bands have at least one band equal
+const (
+	bands    = 8
+	bandBits = 64 / bands
+	// MaxDistance is the largest distance the bands can find
+	MaxDistance = bands - 1
+)
+
+type Config struct {
+	KeyPrefix string
+	// fingerprints are kept for Window
+	Window      time.Duration
+	MaxDistance int
+}
+
+type entry struct {
+	Fingerprint uint64 `json:"fingerprint"`
+	Cluster     string `json:"cluster"`
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	At          int64  `json:"at"`
+}
+
+// Match is the place of an article among the recent ones.
+type Match struct {
+	Fingerprint uint64
+	// Cluster is the fingerprint of the first article of the story
+	Cluster string
+	// DuplicateOf is the url of the closest earlier article, "" if there is none
+	DuplicateOf string
+}
+
+// Index keeps the fingerprints of the recent articles in Redis, shared by the replicas, and finds
+// the near duplicates of an article among them. Every fingerprint is stored in a bucket per band
+// with the urls of the articles.
+type Index struct {
+	rdb redis.UniversalClient
+	cfg Config
+}
+
+func NewIndex(rdb redis.UniversalClient, cfg Config) *Index {
+	return &Index{
+		rdb: rdb,
+		cfg: cfg,
+	}
+}
+
+func (x *Index) urlKey(url string) string {
+	return x.cfg.KeyPrefix + ":url:" + url
+}
+
+func (x *Index) bucketKey(band int, fingerprint uint64) string {
+	value := fingerprint >> (band * bandBits) & (1<<bandBits - 1)
+	return x.cfg.KeyPrefix + ":band:" + strconv.Itoa(band) + ":" + strconv.FormatUint(value, 16)
+}
+
+// Add finds the near duplicates of the text of url and adds it to the index. An article seen
+// before keeps its cluster unless its text changed beyond MaxDistance.
+func (x *Index) Add(ctx context.Context, url, text string) (Match, error) {
+	fingerprint := SimHash(text)
+	now := time.Now()
+
+	prev, err := x.get(ctx, url)
+	if err != nil {
+		return Match{}, err
+	}
+	if prev != nil && Distance(prev.Fingerprint, fingerprint) <= x.cfg.MaxDistance {
+		return Match{Fingerprint: fingerprint, Cluster: prev.Cluster, DuplicateOf: prev.DuplicateOf}, nil
+	}
+
+	e := entry{
+		Fingerprint: fingerprint,
+		Cluster:     strconv.FormatUint(fingerprint, 16),
+		At:          now.Unix(),
+	}
+	closest, err := x.closest(ctx, url, fingerprint, now)
+	if err != nil {
+		return Match{}, err
+	}
+	if closest != nil {
+		e.Cluster = closest.entry.Cluster
+		e.DuplicateOf = closest.url
+	}
+
+	if err := x.put(ctx, url, e); err != nil {
+		return Match{}, err
+	}
+	return Match{Fingerprint: fingerprint, Cluster: e.Cluster, DuplicateOf: e.DuplicateOf}, nil
+}
+
+func (x *Index) get(ctx context.Context, url string) (*entry, error) {
+	raw, err := x.rdb.Get(ctx, x.urlKey(url)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't get fingerprint: %w", err)
+	}
+	var e entry
+	if err := sonic.Unmarshal(raw, &e); err != nil {
+		return nil, fmt.Errorf("can't unmarshal fingerprint: %w", err)
+	}
+	return &e, nil
+}
+
+type candidate struct {
+	url      string
+	entry    entry
+	distance int
+}
+
+// closest returns the closest recent article within MaxDistance, the earliest of the equally close ones.
+func (x *Index) closest(ctx context.Context, url string, fingerprint uint64, now time.Time) (*candidate, error) {
+	pipe := x.rdb.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, bands)
+	for band := range bands {
+		cmds[band] = pipe.HGetAll(ctx, x.bucketKey(band, fingerprint))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("can't get fingerprints: %w", err)
+	}
+
+	var best *candidate
+	stale := x.rdb.Pipeline()
+	for band, cmd := range cmds {
+		for u, raw := range cmd.Val() {
+			if u == url {
+				continue
+			}
+			var e entry
+			if err := sonic.UnmarshalString(raw, &e); err != nil || now.Sub(time.Unix(e.At, 0)) > x.cfg.Window {
+				stale.HDel(ctx, x.bucketKey(band, fingerprint), u)
+				continue
+			}
+			d := Distance(e.Fingerprint, fingerprint)
+			if d > x.cfg.MaxDistance {
+				continue
+			}
+			if best == nil || d < best.distance || d == best.distance && e.At < best.entry.At {
+				best = &candidate{url: u, entry: e, distance: d}
+			}
+		}
+	}
+	if stale.Len() > 0 {
+		if _, err := stale.Exec(ctx); err != nil {
+			return nil, fmt.Errorf("can't remove stale fingerprints: %w", err)
+		}
+	}
+	return best, nil
+}
+
+func (x *Index) put(ctx context.Context, url string, e entry) error {
+	raw, err := sonic.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("can't marshal fingerprint: %w", err)
+	}
+
+	// the buckets live while they get new articles, the stale entries are skipped by the time
+	pipe := x.rdb.Pipeline()
+	pipe.Set(ctx, x.urlKey(url), raw, x.cfg.Window)
+	for band := range bands {
+		key := x.bucketKey(band, e.Fingerprint)
+		pipe.HSet(ctx, key, url, raw)
+		pipe.Expire(ctx, key, x.cfg.Window)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("can't add fingerprint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package dedup
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// words shorter than minWordLength, mostly prepositions and conjunctions, are skipped
+const minWordLength = 3
+
+// SimHash returns the 64 bit SimHash of the words of the text, near-duplicate texts have
+// fingerprints that differ in a few bits. Single words, not shingles, keep the rewritten
+// updates of a story close enough for short news texts.
+func SimHash(text string) uint64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var votes [64]int
+	for _, w := range words {
+		if utf8.RuneCountInString(w) < minWordLength {
+			continue
+		}
+		h := fnv.New64a()
+		h.Write([]byte(w))
+		sum := h.Sum64()
+		for b := range 64 {
+			if sum&(1<<b) != 0 {
+				votes[b]++
+			} else {
+				votes[b]--
+			}
+		}
+	}
+
+	var res uint64
+	for b, v := range votes {
+		if v > 0 {
+			res |= 1 << b
+		}
+	}
+	return res
+}
+
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,79 @@
+package dedup
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+const story = "Банк России сохранил ключевую ставку на уровне 16 процентов годовых. Регулятор отметил, " +
+	"что инфляционное давление остается высоким, а внутренний спрос продолжает опережать возможности " +
+	"расширения производства. Следующее заседание совета директоров по ключевой ставке запланировано " +
+	"на конец июля, аналитики ожидают сохранения жесткой денежно-кредитной политики до конца года."
+
+func TestSimHash(t *testing.T) {
+	tests := []struct {
+		name        string
+		a, b        string
+		maxDistance int
+		minDistance int
+	}{
+		{"same", story, story, 0, 0},
+		{"case and punctuation", story, "БАНК россии -- сохранил ключевую ставку на уровне 16 процентов годовых!!! Регулятор отметил " +
+			"что инфляционное давление остается высоким а внутренний спрос продолжает опережать возможности " +
+			"расширения производства; следующее заседание совета директоров по ключевой ставке запланировано " +
+			"на конец июля аналитики ожидают сохранения жесткой денежно кредитной политики до конца года", 0, 0},
+		{"short words", story, "и в " + story + " на", 0, 0},
+		{"update", story, story + " Рубль после решения укрепился.", MaxDistance, 0},
+		{"other story", story, "Сборная Аргентины обыграла команду Франции в финале чемпионата мира по футболу " +
+			"в серии послематчевых пенальти, Лионель Месси забил дважды и был признан лучшим игроком турнира.", 64, MaxDistance + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Distance(SimHash(tt.a), SimHash(tt.b))
+			if d > tt.maxDistance || d < tt.minDistance {
+				t.Errorf("Distance() = %d, want in [%d, %d]", d, tt.minDistance, tt.maxDistance)
+			}
+		})
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b uint64
+		want int
+	}{
+		{0, 0, 0},
+		{0, 1, 1},
+		{0b1010, 0b0101, 4},
+		{0, ^uint64(0), 64},
+	}
+	for _, tt := range tests {
+		if got := Distance(tt.a, tt.b); got != tt.want {
+			t.Errorf("Distance(%b, %b) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// the fingerprints within MaxDistance must share a bucket, otherwise Add never compares them
+func TestBands(t *testing.T) {
+	x := NewIndex(nil, Config{KeyPrefix: "dedup"})
+	for distance := 0; distance <= MaxDistance; distance++ {
+		for range 1000 {
+			a := rand.Uint64()
+			b := a
+			for _, bit := range rand.Perm(64)[:distance] {
+				b ^= 1 << bit
+			}
+
+			shared := false
+			for band := range bands {
+				if x.bucketKey(band, a) == x.bucketKey(band, b) {
+					shared = true
+				}
+			}
+			if !shared {
+				t.Fatalf("%x and %x at distance %d share no bucket", a, b, distance)
+			}
+		}
+	}
+}
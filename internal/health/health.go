@@ -0,0 +1,91 @@
+package health
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+const checkTimeOut = 3 * time.Second
+
+type Check func(ctx context.Context) error
+
+// Checker runs the named liveness and readiness checks for the probes.
+type Checker struct {
+	mu    sync.RWMutex
+	live  map[string]Check
+	ready map[string]Check
+
+	logger *slog.Logger
+}
+
+func NewChecker(logger *slog.Logger) *Checker {
+	return &Checker{
+		live:   make(map[string]Check),
+		ready:  make(map[string]Check),
+		logger: logger,
+	}
+}
+
+func (c *Checker) AddLiveness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.live[name] = check
+}
+
+func (c *Checker) AddReadiness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ready[name] = check
+}
+
+func (c *Checker) Liveness(w http.ResponseWriter, r *http.Request) {
+	c.serve(w, r, c.live, "liveness")
+}
+
+func (c *Checker) Readiness(w http.ResponseWriter, r *http.Request) {
+	c.serve(w, r, c.ready, "readiness")
+}
+
+func (c *Checker) serve(w http.ResponseWriter, r *http.Request, checks map[string]Check, probe string) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeOut)
+	defer cancel()
+
+	c.mu.RLock()
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	c.mu.RUnlock()
+	sort.Strings(names)
+
+	status := http.StatusOK
+	results := make(map[string]string, len(names))
+	for _, name := range names {
+		c.mu.RLock()
+		check := checks[name]
+		c.mu.RUnlock()
+
+		if err := check(ctx); err != nil {
+			status = http.StatusServiceUnavailable
+			results[name] = err.Error()
+			c.logger.Warn(probe+" check failed", slog.String("check", name), slog.String("error", err.Error()))
+			continue
+		}
+		results[name] = "ok"
+	}
+
+	data, err := sonic.Marshal(results)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
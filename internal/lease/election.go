@@ -0,0 +1,65 @@
+package lease
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Election elects one replica as the leader of name with a lease that the leader holds
+// until it dies or loses it. The others try to take it every third of the TTL.
+type Election struct {
+	m        *Manager
+	name     string
+	leader   atomic.Bool
+	onChange func(leader bool)
+}
+
+// Election creates the election of name, onChange is called when this replica becomes the leader and stops being it.
+func (m *Manager) Election(name string, onChange func(leader bool)) *Election {
+	return &Election{
+		m:        m,
+		name:     name,
+		onChange: onChange,
+	}
+}
+
+func (e *Election) Leader() bool {
+	return e.leader.Load()
+}
+
+func (e *Election) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.m.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		l, err := e.m.TryAcquire(ctx, e.name)
+		if err != nil {
+			e.m.logger.Error(err.Error())
+		}
+		if l != nil {
+			e.set(true)
+			<-l.Context().Done()
+			e.set(false)
+			if ctx.Err() != nil {
+				l.Release(context.WithoutCancel(ctx))
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Election) set(leader bool) {
+	e.leader.Store(leader)
+	e.m.logger.Info("leadership changed", slog.String("election", e.name), slog.Bool("leader", leader))
+	if e.onChange != nil {
+		e.onChange(leader)
+	}
+}
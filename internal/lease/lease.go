@@ -0,0 +1,160 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	renewScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`)
+
+	releaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+)
+
+// Manager hands out leases on named work units in Redis, so the replicas of the scrapper
+// don't do the same work. A lease is renewed while it is held and expires after the TTL
+// when the replica holding it dies.
+type Manager struct {
+	rdb       redis.UniversalClient
+	keyPrefix string
+	owner     string
+	ttl       time.Duration
+	logger    *slog.Logger
+}
+
+func NewManager(rdb redis.UniversalClient, keyPrefix string, ttl time.Duration, logger *slog.Logger) *Manager {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &Manager{
+		rdb:       rdb,
+		keyPrefix: keyPrefix,
+		owner:     host + ":" + strconv.Itoa(os.Getpid()),
+		ttl:       ttl,
+		logger:    logger,
+	}
+}
+
+// Owner is the id of this replica in the leases.
+func (m *Manager) Owner() string {
+	return m.owner
+}
+
+func (m *Manager) key(name string) string {
+	return m.keyPrefix + ":" + name
+}
+
+// TryAcquire takes the lease on name. It returns nil if another replica holds it.
+// The context of the lease is canceled when the lease is lost or released.
+func (m *Manager) TryAcquire(ctx context.Context, name string) (*Lease, error) {
+	ok, err := m.rdb.SetNX(ctx, m.key(name), m.owner, m.ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("can't acquire lease %s: %w", name, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	l := &Lease{
+		m:    m,
+		name: name,
+		done: make(chan struct{}),
+	}
+	l.ctx, l.cancel = context.WithCancel(ctx)
+	go l.renew()
+	return l, nil
+}
+
+// Holder returns the owner of the lease on name, "" if it is free.
+func (m *Manager) Holder(ctx context.Context, name string) (string, error) {
+	owner, err := m.rdb.Get(ctx, m.key(name)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("can't get lease %s: %w", name, err)
+	}
+	return owner, nil
+}
+
+type Lease struct {
+	m      *Manager
+	name   string
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+	done   chan struct{}
+}
+
+func (l *Lease) Context() context.Context {
+	return l.ctx
+}
+
+// Lost reports whether the lease was taken away before it was released.
+func (l *Lease) Lost() bool {
+	select {
+	case <-l.done:
+		return false
+	default:
+		return l.ctx.Err() != nil
+	}
+}
+
+func (l *Lease) renew() {
+	ticker := time.NewTicker(l.m.ttl / 3)
+	defer ticker.Stop()
+
+	renewed := time.Now()
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ok, err := renewScript.Run(l.ctx, l.m.rdb, []string{l.m.key(l.name)}, l.m.owner, l.m.ttl.Milliseconds()).Int()
+		switch {
+		case err != nil && l.ctx.Err() != nil:
+			return
+		case err != nil:
+			l.m.logger.Warn("can't renew lease", slog.String("lease", l.name), slog.String("error", err.Error()))
+			if time.Since(renewed) < l.m.ttl {
+				continue
+			}
+		case ok == 1:
+			renewed = time.Now()
+			continue
+		}
+		l.m.logger.Warn("lease lost", slog.String("lease", l.name))
+		l.cancel()
+		return
+	}
+}
+
+// Release gives the lease back, so another replica may take it at once.
+func (l *Lease) Release(ctx context.Context) {
+	l.once.Do(func() {
+		close(l.done)
+		l.cancel()
+		if err := releaseScript.Run(ctx, l.m.rdb, []string{l.m.key(l.name)}, l.m.owner).Err(); err != nil {
+			l.m.logger.Error("can't release lease", slog.String("lease", l.name), slog.String("error", err.Error()))
+		}
+	})
+}
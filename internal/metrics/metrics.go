@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "scrapper"
+
+var (
+	PagesFetched = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pages_fetched_total",
+		Help:      "Fetched pages by source and response status, 0 is for network errors.",
+	}, []string{"source", "status"})
+
+	ArticlesExtracted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "articles_extracted_total",
+		Help:      "Articles found on the fetched pages.",
+	}, []string{"source"})
+
+	ArticlesPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "articles_published_total",
+		Help:      "Articles published to the queue.",
+	}, []string{"source", "partition"})
+
+	ArticlesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "articles_failed_total",
+		Help:      "Articles that could not be published.",
+	}, []string{"source", "partition"})
+
+	ExtractionFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "extraction_failures_total",
+		Help:      "Failures to extract a field of an article by selector.",
+	}, []string{"source", "selector"})
+
+	CurrentDay = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "current_day_timestamp_seconds",
+		Help:      "Day that is being scraped or was scraped last in the lane.",
+	}, []string{"source", "lane"})
+
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "proxy_request_duration_seconds",
+		Help:      "Time to get the response headers through the proxy.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"proxy"})
+
+	ProxyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_requests_total",
+		Help:      "Requests sent through the proxy by error class, empty for successful ones.",
+	}, []string{"proxy", "class"})
+
+	ProxyBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_bytes_total",
+		Help:      "Traffic through the proxy by direction: in or out.",
+	}, []string{"proxy", "direction"})
+
+	LaneInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "lane_in_flight",
+		Help:      "Requests in flight by priority lane: live or backfill.",
+	}, []string{"lane"})
+
+	LaneShare = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "lane_capacity_share",
+		Help:      "Share of the concurrency limit used by the priority lane.",
+	}, []string{"lane"})
+
+	LaneWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "lane_wait_seconds",
+		Help:      "Time a request waited for a slot by priority lane.",
+		Buckets:   []float64{0.01, 0.1, 0.5, 1, 5, 10, 30, 60},
+	}, []string{"lane"})
+
+	FieldCoverage = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "extraction_coverage",
+		Help:      "Share of the articles in the quality window with the field extracted.",
+	}, []string{"source", "field"})
+
+	QualityAlert = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "extraction_alert",
+		Help:      "1 while the coverage of the field is below the threshold.",
+	}, []string{"source", "field"})
+
+	BreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_breaker_state",
+		Help:      "State of the circuit breaker of the domain: 0 closed, 1 open, 2 half-open.",
+	}, []string{"host"})
+)
+
+// RegisterProxyPool exposes the proxy pool sizes, pool returns the number of proxies by state.
+func RegisterProxyPool(pool func() map[string]int) {
+	for _, state := range []string{"accessible", "inaccessible", "exhausted"} {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "proxy_pool_size",
+			Help:        "Proxies in the pool by state.",
+			ConstLabels: prometheus.Labels{"state": state},
+		}, func() float64 {
+			return float64(pool()[state])
+		})
+	}
+}
+
+func RegisterConcurrencyLimit(limit func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "concurrency_limit",
+		Help:      "Current limit of the requests in flight.",
+	}, func() float64 {
+		return float64(limit())
+	})
+}
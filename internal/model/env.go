@@ -1,9 +0,0 @@
-package model
-
-const (
-	EnvRedisHost     = "REDIS_HOST"
-	EnvRedisPort     = "REDIS_PORT"
-	EnvRedisPassword = "REDIS_PASSWORD"
-
-	EnvProxyUrls = "PROXIES"
-)
@@ -1,13 +1,35 @@
 package model
 
+const (
+	// ArticleUpdated is the event of an article published again after it changed on the page.
+	ArticleUpdated = "article_updated"
+	// ArticleReparsed is the event of an article published again from the archive after the extraction changed.
+	ArticleReparsed = "article_reparsed"
+)
+
 type ScrapperPayload struct {
 	Url  string `json:"url"`
 	Date string `json:"date"`
+	// Text is normalized, without the boilerplate, one paragraph per line
 	Text string `json:"text"`
+	// RawText is the text blocks as they are on the page
+	RawText string `json:"raw_text"`
+	// ArchiveID is the WARC-Record-ID of the raw response in the archive
+	ArchiveID string `json:"archive_id,omitempty"`
+	// Fingerprint is the SimHash of Text, ClusterID is the fingerprint of the first article of the story
+	// and DuplicateOf is the url of the closest earlier near duplicate
+	Fingerprint string `json:"fingerprint,omitempty"`
+	ClusterID   string `json:"cluster_id,omitempty"`
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	// Event is ArticleUpdated for a new Revision of the article, starting from 1,
+	// and ArticleReparsed, without a revision, for an article extracted again from the archive
+	Event    string `json:"event,omitempty"`
+	Revision int    `json:"revision,omitempty"`
 }
 
 type DonePayload struct {
 	Date     string `json:"date"`
 	Count    int    `json:"count"`
+	Failed   int    `json:"failed"`
 	Duration string `json:"duration"`
 }
@@ -0,0 +1,416 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/vhlebnikov/colly/v2"
+
+	"github.com/STTM-NSU/web-scrapper/internal/metrics"
+	"github.com/STTM-NSU/web-scrapper/internal/retry"
+)
+
+const (
+	Daily   = "daily"
+	Monthly = "monthly"
+)
+
+const (
+	statsFlushInterval = 10 * time.Second
+	dailyStatsTTL      = 40 * 24 * time.Hour
+	monthlyStatsTTL    = 400 * 24 * time.Hour
+)
+
+type Quota struct {
+	Host     string `yaml:"host"`
+	Period   string `yaml:"period"`
+	Requests int64  `yaml:"requests"`
+	Bytes    int64  `yaml:"bytes"`
+}
+
+type Usage struct {
+	Requests int64            `json:"requests"`
+	BytesIn  int64            `json:"bytes_in"`
+	BytesOut int64            `json:"bytes_out"`
+	Errors   map[string]int64 `json:"errors,omitempty"`
+}
+
+func (u *Usage) add(delta Usage) {
+	u.Requests += delta.Requests
+	u.BytesIn += delta.BytesIn
+	u.BytesOut += delta.BytesOut
+	for class, n := range delta.Errors {
+		if u.Errors == nil {
+			u.Errors = make(map[string]int64)
+		}
+		u.Errors[class] += n
+	}
+}
+
+type pendingKey struct {
+	host  string
+	day   string
+	month string
+}
+
+// Accountant counts requests, traffic and errors of every proxy for the current day and month.
+// Counters are flushed to Redis periodically, so they survive restarts, and are checked
+// against the proxy quotas.
+type Accountant struct {
+	rdb       redis.UniversalClient
+	keyPrefix string
+	logger    *slog.Logger
+	quotas    map[string]Quota
+
+	lastResponse atomic.Int64
+
+	mu        sync.Mutex
+	day       string
+	month     string
+	daily     map[string]*Usage
+	monthly   map[string]*Usage
+	pending   map[pendingKey]*Usage
+	exhausted map[string]bool
+	// loaded are the hosts whose counters were read from Redis, since then all their usage is counted in memory
+	loaded map[string]bool
+}
+
+// NewAccountant creates the accountant, rdb may be nil if the counters are not to be saved,
+// then Load and Flush must not be called.
+func NewAccountant(rdb redis.UniversalClient, keyPrefix string, quotas []Quota, logger *slog.Logger) *Accountant {
+	a := &Accountant{
+		rdb:       rdb,
+		keyPrefix: keyPrefix,
+		logger:    logger,
+		quotas:    make(map[string]Quota, len(quotas)),
+		daily:     make(map[string]*Usage),
+		monthly:   make(map[string]*Usage),
+		pending:   make(map[pendingKey]*Usage),
+		exhausted: make(map[string]bool),
+		loaded:    make(map[string]bool),
+	}
+	for _, q := range quotas {
+		a.quotas[q.Host] = q
+	}
+	a.rollover(time.Now())
+	return a
+}
+
+func (a *Accountant) dayKey(host, day string) string {
+	return a.keyPrefix + ":" + host + ":day:" + day
+}
+
+func (a *Accountant) monthKey(host, month string) string {
+	return a.keyPrefix + ":" + host + ":month:" + month
+}
+
+// Load reads the counters of the current day and month of the hosts from Redis. The hosts that
+// were loaded before are skipped, their flushed usage is in the counters in memory already.
+func (a *Accountant) Load(ctx context.Context, hosts ...string) error {
+	a.mu.Lock()
+	day, month := a.day, a.month
+	a.mu.Unlock()
+
+	for _, host := range hosts {
+		a.mu.Lock()
+		loaded := a.loaded[host]
+		a.mu.Unlock()
+		if loaded {
+			continue
+		}
+
+		daily, err := a.read(ctx, a.dayKey(host, day))
+		if err != nil {
+			return err
+		}
+		monthly, err := a.read(ctx, a.monthKey(host, month))
+		if err != nil {
+			return err
+		}
+
+		a.mu.Lock()
+		if a.day == day && !a.loaded[host] {
+			a.usage(a.daily, host).add(daily)
+			a.usage(a.monthly, host).add(monthly)
+			a.loaded[host] = true
+			a.check(host)
+		}
+		a.mu.Unlock()
+	}
+	return nil
+}
+
+func (a *Accountant) read(ctx context.Context, key string) (Usage, error) {
+	var u Usage
+	fields, err := a.rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		return u, fmt.Errorf("can't read proxy stats %s: %w", key, err)
+	}
+	for field, value := range fields {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return u, fmt.Errorf("bad proxy stats field %s=%s: %w", field, value, err)
+		}
+		switch {
+		case field == "requests":
+			u.Requests = n
+		case field == "bytes_in":
+			u.BytesIn = n
+		case field == "bytes_out":
+			u.BytesOut = n
+		case strings.HasPrefix(field, "errors:"):
+			u.add(Usage{Errors: map[string]int64{strings.TrimPrefix(field, "errors:"): n}})
+		}
+	}
+	return u, nil
+}
+
+func (a *Accountant) usage(m map[string]*Usage, host string) *Usage {
+	u, ok := m[host]
+	if !ok {
+		u = &Usage{}
+		m[host] = u
+	}
+	return u
+}
+
+func (a *Accountant) rollover(now time.Time) {
+	day, month := now.Format("20060102"), now.Format("200601")
+	if day != a.day {
+		a.day = day
+		clear(a.daily)
+		for host := range a.exhausted {
+			if a.quotas[host].Period != Monthly {
+				delete(a.exhausted, host)
+			}
+		}
+	}
+	if month != a.month {
+		a.month = month
+		clear(a.monthly)
+		clear(a.exhausted)
+	}
+}
+
+func (a *Accountant) record(host string, delta Usage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rollover(time.Now())
+	a.usage(a.daily, host).add(delta)
+	a.usage(a.monthly, host).add(delta)
+
+	key := pendingKey{host: host, day: a.day, month: a.month}
+	p, ok := a.pending[key]
+	if !ok {
+		p = &Usage{}
+		a.pending[key] = p
+	}
+	p.add(delta)
+	a.check(host)
+}
+
+func (a *Accountant) check(host string) {
+	q, ok := a.quotas[host]
+	if !ok || a.exhausted[host] {
+		return
+	}
+	u := a.daily[host]
+	if q.Period == Monthly {
+		u = a.monthly[host]
+	}
+	if u == nil {
+		return
+	}
+	if q.Requests > 0 && u.Requests >= q.Requests || q.Bytes > 0 && u.BytesIn+u.BytesOut >= q.Bytes {
+		a.exhausted[host] = true
+		a.logger.Info("proxy quota is used up",
+			slog.String("proxy", host),
+			slog.String("period", q.Period),
+			slog.Int64("requests", u.Requests),
+			slog.Int64("bytes", u.BytesIn+u.BytesOut))
+	}
+}
+
+// Exhausted reports whether the proxy has used up its quota for the current period.
+func (a *Accountant) Exhausted(host string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rollover(time.Now())
+	return a.exhausted[host]
+}
+
+// LastResponse returns the time the last response came through any proxy.
+func (a *Accountant) LastResponse() time.Time {
+	if n := a.lastResponse.Load(); n != 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
+
+// Usage returns the counters of the proxy for the current day and month.
+func (a *Accountant) Usage(host string) (Usage, Usage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var daily, monthly Usage
+	if u, ok := a.daily[host]; ok {
+		daily.add(*u)
+	}
+	if u, ok := a.monthly[host]; ok {
+		monthly.add(*u)
+	}
+	return daily, monthly
+}
+
+func (a *Accountant) Run(ctx context.Context) {
+	ticker := time.NewTicker(statsFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// the last flush must not be canceled with the service context
+			if err := a.Flush(context.WithoutCancel(ctx)); err != nil {
+				a.logger.Error("can't flush proxy stats: " + err.Error())
+			}
+			return
+		case <-ticker.C:
+			if err := a.Flush(ctx); err != nil {
+				a.logger.Error("can't flush proxy stats: " + err.Error())
+			}
+		}
+	}
+}
+
+func (a *Accountant) Flush(ctx context.Context) error {
+	a.mu.Lock()
+	pending := a.pending
+	a.pending = make(map[pendingKey]*Usage)
+	a.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// not a transaction, the keys may be in different slots of a cluster
+	pipe := a.rdb.Pipeline()
+	for key, u := range pending {
+		for redisKey, ttl := range map[string]time.Duration{
+			a.dayKey(key.host, key.day):     dailyStatsTTL,
+			a.monthKey(key.host, key.month): monthlyStatsTTL,
+		} {
+			pipe.HIncrBy(ctx, redisKey, "requests", u.Requests)
+			pipe.HIncrBy(ctx, redisKey, "bytes_in", u.BytesIn)
+			pipe.HIncrBy(ctx, redisKey, "bytes_out", u.BytesOut)
+			for class, n := range u.Errors {
+				pipe.HIncrBy(ctx, redisKey, "errors:"+class, n)
+			}
+			pipe.Expire(ctx, redisKey, ttl)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		// counters are put back to be flushed next time
+		a.mu.Lock()
+		for key, u := range pending {
+			p, ok := a.pending[key]
+			if !ok {
+				p = &Usage{}
+				a.pending[key] = p
+			}
+			p.add(*u)
+		}
+		a.mu.Unlock()
+		return fmt.Errorf("can't write proxy stats: %w", err)
+	}
+	return nil
+}
+
+// AccountingTransport records every request to the accountant of the proxy it was sent through.
+// Sizes of the headers are estimated, bodies are counted as they are read.
+type AccountingTransport struct {
+	Base       http.RoundTripper
+	Accountant *Accountant
+}
+
+func (t *AccountingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.Base.RoundTrip(req)
+
+	// the proxy header is set by the switcher when the transport picks the proxy
+	pr, parseErr := url.Parse(req.Header.Get(colly.ProxyUrlHeader))
+	if parseErr != nil || pr.Host == "" {
+		return resp, err
+	}
+
+	delta := Usage{
+		Requests: 1,
+		BytesOut: requestSize(req),
+	}
+	var status int
+	if resp != nil {
+		t.Accountant.lastResponse.Store(time.Now().UnixNano())
+		status = resp.StatusCode
+		delta.BytesIn = headerSize(resp.Header) + int64(len(resp.Status))
+	}
+	var errClass string
+	if class := retry.Classify(status, err); class != retry.Unknown {
+		errClass = class.String()
+		delta.Errors = map[string]int64{errClass: 1}
+	}
+	t.Accountant.record(pr.Host, delta)
+
+	metrics.RequestDuration.WithLabelValues(pr.Host).Observe(time.Since(start).Seconds())
+	metrics.ProxyRequests.WithLabelValues(pr.Host, errClass).Inc()
+	metrics.ProxyBytes.WithLabelValues(pr.Host, "out").Add(float64(delta.BytesOut))
+	metrics.ProxyBytes.WithLabelValues(pr.Host, "in").Add(float64(delta.BytesIn))
+
+	if resp != nil && resp.Body != nil {
+		resp.Body = &countingBody{ReadCloser: resp.Body, host: pr.Host, accountant: t.Accountant}
+	}
+	return resp, err
+}
+
+type countingBody struct {
+	io.ReadCloser
+	host       string
+	accountant *Accountant
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.accountant.record(b.host, Usage{BytesIn: int64(n)})
+		metrics.ProxyBytes.WithLabelValues(b.host, "in").Add(float64(n))
+	}
+	return n, err
+}
+
+func requestSize(req *http.Request) int64 {
+	size := int64(len(req.Method)+len(req.URL.String())) + headerSize(req.Header)
+	if req.ContentLength > 0 {
+		size += req.ContentLength
+	}
+	return size
+}
+
+func headerSize(h http.Header) int64 {
+	var size int64
+	for k, vs := range h {
+		for _, v := range vs {
+			size += int64(len(k) + len(v) + 4)
+		}
+	}
+	return size
+}
@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+// Limiter is a set of token buckets, one for every proxy and target host pair.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+}
+
+func NewLimiter(requestsPerSecond float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    requestsPerSecond,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// SetRate changes the rate and the burst of all the buckets.
+func (l *Limiter) SetRate(requestsPerSecond float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = requestsPerSecond
+	l.burst = float64(burst)
+	for _, b := range l.buckets {
+		b.tokens = min(l.burst, b.tokens)
+	}
+}
+
+func limiterKey(proxy, host string) string {
+	return proxy + "|" + host
+}
+
+func (l *Limiter) get(key string, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// Reserve takes a token from the proxy and host bucket and returns how long the caller
+// has to wait before sending the request. It returns false if the pair is paused.
+func (l *Limiter) Reserve(proxy, host string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b := l.get(limiterKey(proxy, host), now)
+	if now.Before(b.pausedUntil) {
+		return 0, false
+	}
+
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0, true
+	}
+	return time.Duration(-b.tokens / l.rate * float64(time.Second)), true
+}
+
+// Pause stops the proxy and host pair from being used until d has passed.
+func (l *Limiter) Pause(proxy, host string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b := l.get(limiterKey(proxy, host), now)
+	if until := now.Add(d); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+	b.tokens = 0
+	b.last = now.Add(d)
+}
+
+// PausedFor returns how long the proxy and host pair stays paused.
+func (l *Limiter) PausedFor(proxy, host string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[limiterKey(proxy, host)]
+	if !ok {
+		return 0
+	}
+	return max(0, time.Until(b.pausedUntil))
+}
+
+// ParseRetryAfter reads the Retry-After header in both delay-seconds and HTTP-date forms.
+func ParseRetryAfter(h http.Header, fallback time.Duration) time.Duration {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return fallback
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(v); err == nil {
+		return max(0, time.Until(date))
+	}
+	return fallback
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// the waits are compared with a margin for the time passed between the calls
+const margin = 50 * time.Millisecond
+
+func near(got, want time.Duration) bool {
+	return got >= want-margin && got <= want+margin
+}
+
+func TestLimiterReserve(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		want  []time.Duration
+	}{
+		{"burst", 1, 3, []time.Duration{0, 0, 0, time.Second, 2 * time.Second}},
+		{"no burst", 2, 1, []time.Duration{0, 500 * time.Millisecond, time.Second}},
+		{"fast", 100, 1, []time.Duration{0, 10 * time.Millisecond, 20 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(tt.rate, tt.burst)
+			for i, want := range tt.want {
+				got, ok := l.Reserve("proxy:1", "www.rbc.ru")
+				if !ok || !near(got, want) {
+					t.Errorf("Reserve() #%d = %s, %t, want %s, true", i, got, ok, want)
+				}
+			}
+
+			// the buckets of the other pairs are full
+			if got, _ := l.Reserve("proxy:2", "www.rbc.ru"); got != 0 {
+				t.Errorf("Reserve() of another proxy = %s, want 0", got)
+			}
+			if got, _ := l.Reserve("proxy:1", "rbc.ru"); got != 0 {
+				t.Errorf("Reserve() of another host = %s, want 0", got)
+			}
+		})
+	}
+}
+
+func TestLimiterSetRate(t *testing.T) {
+	l := NewLimiter(1, 10)
+	l.Reserve("proxy:1", "www.rbc.ru")
+	l.SetRate(1, 2)
+
+	// 9 tokens are cut to the new burst of 2
+	want := []time.Duration{0, 0, time.Second}
+	for i, w := range want {
+		if got, _ := l.Reserve("proxy:1", "www.rbc.ru"); !near(got, w) {
+			t.Errorf("Reserve() #%d = %s, want %s", i, got, w)
+		}
+	}
+}
+
+func TestLimiterPause(t *testing.T) {
+	l := NewLimiter(10, 5)
+	l.Pause("proxy:1", "www.rbc.ru", time.Minute)
+	// a shorter pause doesn't cut the longer one
+	l.Pause("proxy:1", "www.rbc.ru", time.Second)
+
+	if _, ok := l.Reserve("proxy:1", "www.rbc.ru"); ok {
+		t.Error("Reserve() of a paused pair succeeded")
+	}
+	if got := l.PausedFor("proxy:1", "www.rbc.ru"); !near(got, time.Minute) {
+		t.Errorf("PausedFor() = %s, want %s", got, time.Minute)
+	}
+	if _, ok := l.Reserve("proxy:2", "www.rbc.ru"); !ok {
+		t.Error("Reserve() of another proxy failed")
+	}
+	if got := l.PausedFor("proxy:3", "www.rbc.ru"); got != 0 {
+		t.Errorf("PausedFor() of an unknown pair = %s, want 0", got)
+	}
+
+	l = NewLimiter(10, 5)
+	l.Pause("proxy:1", "www.rbc.ru", 20*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	// the bucket is empty after the pause and refills from its end
+	got, ok := l.Reserve("proxy:1", "www.rbc.ru")
+	if !ok || got > 100*time.Millisecond || got < 50*time.Millisecond {
+		t.Errorf("Reserve() after the pause = %s, %t, want about 90ms, true", got, ok)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	fallback := 30 * time.Second
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"none", "", fallback},
+		{"seconds", "120", 2 * time.Minute},
+		{"zero", "0", 0},
+		{"spaces", " 5 ", 5 * time.Second},
+		{"negative", "-5", fallback},
+		{"date", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), time.Minute},
+		{"past date", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0},
+		{"garbage", "soon", fallback},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.value != "" {
+				h.Set("Retry-After", tt.value)
+			}
+			// the dates have a second precision
+			if got := ParseRetryAfter(h, fallback); got < tt.want-time.Second || got > tt.want {
+				t.Errorf("ParseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
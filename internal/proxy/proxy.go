@@ -2,7 +2,6 @@ package proxy
 
 import (
 	"context"
-	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
@@ -19,10 +18,13 @@ type Command int
 const (
 	Add Command = iota + 1
 	Delete
+	Remove
 )
 
 const RecoverTimeOut = 5 * time.Minute
 
+const quotaRecheckInterval = time.Minute
+
 type CommandMessage struct {
 	Cmd Command
 	Url *url.URL
@@ -37,16 +39,26 @@ type MyRoundRobinSwitcher struct {
 	cmdChan       chan CommandMessage
 	haveProxyChan chan struct{}
 	recoverPool   []*url.URL
+	limiter       *Limiter
+	accountant    *Accountant
+	sessions      *Sessions
+	waiting       atomic.Int32
+	waitingSince  atomic.Int64
+
+	// successes is the time of the last response through every proxy, by target host
+	successMu sync.Mutex
+	successes map[string]map[string]time.Time
 
 	proxyRecoverTimeOut time.Duration
 }
 
-func MyRoundRobinProxySwitcher(proxies string, log *slog.Logger, proxyRecoverTimeOutSeconds int) (*MyRoundRobinSwitcher, error) {
+func MyRoundRobinProxySwitcher(proxies string, log *slog.Logger, proxyRecoverTimeOutSeconds int, limiter *Limiter, accountant *Accountant) (*MyRoundRobinSwitcher, error) {
 
-	if len(proxies) == 0 {
-		return nil, fmt.Errorf("no proxy")
+	// there are none when the pages are replayed from the cassette
+	var proxyUrls []string
+	if len(proxies) > 0 {
+		proxyUrls = strings.Split(proxies, ",")
 	}
-	proxyUrls := strings.Split(proxies, ",")
 	for i, proxy := range proxyUrls {
 		proxyUrls[i] = "http://" + proxy
 	}
@@ -57,6 +69,10 @@ func MyRoundRobinProxySwitcher(proxies string, log *slog.Logger, proxyRecoverTim
 		haveProxyChan:       make(chan struct{}),
 		recoverPool:         make([]*url.URL, 0, len(proxyUrls)),
 		logger:              log,
+		limiter:             limiter,
+		accountant:          accountant,
+		sessions:            newSessions(log),
+		successes:           make(map[string]map[string]time.Time),
 		proxyRecoverTimeOut: time.Duration(proxyRecoverTimeOutSeconds) * time.Second,
 	}
 
@@ -72,30 +88,253 @@ func MyRoundRobinProxySwitcher(proxies string, log *slog.Logger, proxyRecoverTim
 }
 
 func (r *MyRoundRobinSwitcher) GetProxy(pr *http.Request) (*url.URL, error) {
+	host := pr.URL.Hostname()
+	id := sessionID(pr.Context())
 	for {
+		if id != "" {
+			if u := r.sessions.proxy(id); u != nil && r.usable(u) {
+				// the session waits for its proxy instead of moving to another one
+				wait, ok := r.limiter.Reserve(u.String(), host)
+				if !ok {
+					wait = r.limiter.PausedFor(u.String(), host)
+				}
+				if err := sleepCtx(pr.Context(), wait); err != nil {
+					return nil, err
+				}
+				if ok {
+					return r.use(pr, u), nil
+				}
+				continue
+			}
+		}
+
 		r.mu.RLock()
 		proxyCount := len(r.proxyURLs)
+		haveProxy := r.haveProxyChan
 		r.mu.RUnlock()
 
 		if proxyCount > 0 {
-			break
+			u, wait, paused := r.next(host)
+			if u == nil {
+				r.logger.Debug("all proxies are paused for host", slog.String("host", host), slog.Duration("wait", paused))
+				if err := sleepCtx(pr.Context(), paused); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := sleepCtx(pr.Context(), wait); err != nil {
+				return nil, err
+			}
+			if id != "" {
+				r.sessions.bind(id, u)
+			}
+			return r.use(pr, u), nil
 		}
 
 		r.logger.Info("waiting for proxy")
-		<-r.haveProxyChan
+		if r.waiting.Add(1) == 1 {
+			r.waitingSince.Store(time.Now().UnixNano())
+		}
+		select {
+		case <-pr.Context().Done():
+			r.waiting.Add(-1)
+			return nil, pr.Context().Err()
+		case <-haveProxy:
+			r.waiting.Add(-1)
+		}
 	}
+}
 
-	r.mu.RLock()
-	index := atomic.AddUint32(&r.index, 1) - 1
-	u := r.proxyURLs[index%uint32(len(r.proxyURLs))]
-	r.mu.RUnlock()
-
+func (r *MyRoundRobinSwitcher) use(pr *http.Request, u *url.URL) *url.URL {
 	uStr := u.String()
-
 	ctx := context.WithValue(pr.Context(), colly.ProxyURLKey, uStr)
 	*pr = *pr.WithContext(ctx)
 	pr.Header.Set(colly.ProxyUrlHeader, uStr)
-	return u, nil
+	return u
+}
+
+// usable reports whether the proxy is in rotation and has quota left.
+func (r *MyRoundRobinSwitcher) usable(u *url.URL) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, p := range r.proxyURLs {
+		if p.String() == u.String() {
+			return !r.accountant.Exhausted(u.Host)
+		}
+	}
+	return false
+}
+
+// next picks the next proxy in rotation that is not paused for host and has quota left.
+// If there is no such proxy, it returns nil and the time until one of them can be usable again.
+func (r *MyRoundRobinSwitcher) next(host string) (*url.URL, time.Duration, time.Duration) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	n := uint32(len(r.proxyURLs))
+	if n == 0 {
+		return nil, 0, time.Second
+	}
+	start := atomic.AddUint32(&r.index, 1) - 1
+	minPaused := time.Duration(-1)
+	for i := uint32(0); i < n; i++ {
+		u := r.proxyURLs[(start+i)%n]
+		if r.accountant.Exhausted(u.Host) {
+			if minPaused < 0 || quotaRecheckInterval < minPaused {
+				minPaused = quotaRecheckInterval
+			}
+			continue
+		}
+		if wait, ok := r.limiter.Reserve(u.String(), host); ok {
+			return u, wait, 0
+		}
+		if paused := r.limiter.PausedFor(u.String(), host); minPaused < 0 || paused < minPaused {
+			minPaused = paused
+		}
+	}
+	return nil, 0, max(minPaused, 10*time.Millisecond)
+}
+
+// Throttle pauses the proxy for host, so the following requests to host go through other proxies.
+func (r *MyRoundRobinSwitcher) Throttle(proxyURL, host string, d time.Duration) {
+	// the url may have the credentials in it
+	var proxyHost string
+	if u, err := url.Parse(proxyURL); err == nil {
+		proxyHost = u.Host
+	}
+	r.logger.Info("throttle proxy",
+		slog.String("proxy", proxyHost),
+		slog.String("host", host),
+		slog.Duration("retry after", d))
+	r.limiter.Pause(proxyURL, host, d)
+}
+
+// Succeeded records a response from host through the proxy.
+func (r *MyRoundRobinSwitcher) Succeeded(proxyURL, host string) {
+	r.successMu.Lock()
+	defer r.successMu.Unlock()
+
+	proxies, ok := r.successes[host]
+	if !ok {
+		proxies = make(map[string]time.Time)
+		r.successes[host] = proxies
+	}
+	proxies[proxyURL] = time.Now()
+}
+
+// OthersSucceeded reports whether host responded through any other proxy within the last d.
+// A proxy is to blame for its failures only then, otherwise it is the host that fails.
+func (r *MyRoundRobinSwitcher) OthersSucceeded(proxyURL, host string, d time.Duration) bool {
+	r.successMu.Lock()
+	defer r.successMu.Unlock()
+
+	for p, at := range r.successes[host] {
+		if p != proxyURL && time.Since(at) <= d {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitingFor returns how long requests have been waiting for any proxy to appear in rotation.
+func (r *MyRoundRobinSwitcher) WaitingFor() time.Duration {
+	if r.waiting.Load() == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, r.waitingSince.Load()))
+}
+
+// Healthy returns the number of proxies in rotation that have quota left.
+func (r *MyRoundRobinSwitcher) Healthy() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var n int
+	for _, u := range r.proxyURLs {
+		if !r.accountant.Exhausted(u.Host) {
+			n++
+		}
+	}
+	return n
+}
+
+// QuotaExhausted reports whether there are proxies in rotation and all of them are out of quota,
+// so the requests wait for the quotas to reset.
+func (r *MyRoundRobinSwitcher) QuotaExhausted() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.proxyURLs {
+		if !r.accountant.Exhausted(u.Host) {
+			return false
+		}
+	}
+	return len(r.proxyURLs) > 0
+}
+
+// URLs returns all the known proxies, including the ones waiting for recover.
+func (r *MyRoundRobinSwitcher) URLs() []*url.URL {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	urls := make([]*url.URL, 0, len(r.proxyURLs)+len(r.recoverPool))
+	urls = append(urls, r.proxyURLs...)
+	urls = append(urls, r.recoverPool...)
+	return urls
+}
+
+func (r *MyRoundRobinSwitcher) Hosts() []string {
+	urls := r.URLs()
+	hosts := make([]string, 0, len(urls))
+	for _, u := range urls {
+		hosts = append(hosts, u.Host)
+	}
+	return hosts
+}
+
+type Pool struct {
+	Accessible   []string `json:"accessible"`
+	Inaccessible []string `json:"inaccessible"`
+}
+
+// Pool returns the hosts of the proxies in rotation and of the ones waiting for recover.
+func (r *MyRoundRobinSwitcher) Pool() Pool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	pool := Pool{
+		Accessible:   make([]string, 0, len(r.proxyURLs)),
+		Inaccessible: make([]string, 0, len(r.recoverPool)),
+	}
+	for _, u := range r.proxyURLs {
+		pool.Accessible = append(pool.Accessible, u.Host)
+	}
+	for _, u := range r.recoverPool {
+		pool.Inaccessible = append(pool.Inaccessible, u.Host)
+	}
+	return pool
+}
+
+// PoolSizes returns the number of proxies in rotation, waiting for recover
+// and out of rotation because of the quota.
+func (r *MyRoundRobinSwitcher) PoolSizes() map[string]int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sizes := map[string]int{"inaccessible": len(r.recoverPool)}
+	for _, u := range r.proxyURLs {
+		if r.accountant.Exhausted(u.Host) {
+			sizes["exhausted"]++
+		} else {
+			sizes["accessible"]++
+		}
+	}
+	return sizes
+}
+
+func (r *MyRoundRobinSwitcher) Sessions() *Sessions {
+	return r.sessions
 }
 
 func (r *MyRoundRobinSwitcher) GetCmdChan() chan<- CommandMessage {
@@ -120,25 +359,39 @@ func (r *MyRoundRobinSwitcher) Run(ctx context.Context) {
 						slog.Int("proxy accessible", len(r.proxyURLs)),
 						slog.Int("proxy inaccessible", len(r.recoverPool)))
 
-					for i := range r.recoverPool {
-						if r.proxyURLs[i].String() == v.Url.String() {
-							r.proxyURLs = append(r.proxyURLs, r.recoverPool[i])
-							if len(r.recoverPool) == 1 {
-								r.recoverPool = []*url.URL{}
-							} else {
-								r.recoverPool = append(r.recoverPool[:i], r.recoverPool[i+1:]...)
-							}
+					if indexOf(r.proxyURLs, v.Url) >= 0 {
+						return
+					}
+					u := v.Url
+					if i := indexOf(r.recoverPool, v.Url); i >= 0 {
+						u = r.recoverPool[i]
+						r.recoverPool = append(r.recoverPool[:i], r.recoverPool[i+1:]...)
+					}
+					r.proxyURLs = append(r.proxyURLs, u)
 
-							r.logger.Info("Add proxy to proxyURLs", slog.String("url", v.Url.String()),
-								slog.Int("proxy accessible", len(r.proxyURLs)),
-								slog.Int("proxy inaccessible", len(r.recoverPool)))
-							if len(r.proxyURLs) == 1 {
-								r.haveProxyChan <- struct{}{}
-							}
-							break
-						}
+					r.logger.Info("Add proxy to proxyURLs", slog.String("url", v.Url.String()),
+						slog.Int("proxy accessible", len(r.proxyURLs)),
+						slog.Int("proxy inaccessible", len(r.recoverPool)))
+					if len(r.proxyURLs) == 1 {
+						// wakes up every request waiting for a proxy
+						close(r.haveProxyChan)
+						r.haveProxyChan = make(chan struct{})
+					}
+				}()
+			case Remove:
+				func() {
+					r.mu.Lock()
+					defer r.mu.Unlock()
 
+					if i := indexOf(r.proxyURLs, v.Url); i >= 0 {
+						r.proxyURLs = append(r.proxyURLs[:i], r.proxyURLs[i+1:]...)
 					}
+					if i := indexOf(r.recoverPool, v.Url); i >= 0 {
+						r.recoverPool = append(r.recoverPool[:i], r.recoverPool[i+1:]...)
+					}
+					r.logger.Info("Remove proxy", slog.String("proxy", v.Url.Host),
+						slog.Int("proxy accessible", len(r.proxyURLs)),
+						slog.Int("proxy inaccessible", len(r.recoverPool)))
 				}()
 			case Delete:
 				func() {
@@ -209,3 +462,26 @@ func (r *MyRoundRobinSwitcher) RunForRecover(ctx context.Context) {
 		}
 	}
 }
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func indexOf(urls []*url.URL, u *url.URL) int {
+	for i := range urls {
+		if urls[i].String() == u.String() {
+			return i
+		}
+	}
+	return -1
+}
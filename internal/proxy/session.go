@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+)
+
+// SessionHeader marks the requests of a crawl chain. The header is removed before the request is sent.
+const SessionHeader = "X-Scrapper-Session"
+
+type sessionKey struct{}
+
+type session struct {
+	proxy *url.URL
+	jar   http.CookieJar
+}
+
+// Sessions binds crawl chains to proxies. Every session has its own cookie jar,
+// which is cleared when the session moves to another proxy.
+type Sessions struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+	logger   *slog.Logger
+}
+
+func newSessions(logger *slog.Logger) *Sessions {
+	return &Sessions{
+		sessions: make(map[string]*session),
+		logger:   logger,
+	}
+}
+
+func (s *Sessions) get(id string) *session {
+	sess, ok := s.sessions[id]
+	if !ok {
+		jar, _ := cookiejar.New(nil)
+		sess = &session{jar: jar}
+		s.sessions[id] = sess
+	}
+	return sess
+}
+
+func (s *Sessions) proxy(id string) *url.URL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(id).proxy
+}
+
+func (s *Sessions) bind(id string, u *url.URL) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess := s.get(id)
+	if sess.proxy != nil && sess.proxy.String() == u.String() {
+		return
+	}
+	if sess.proxy != nil {
+		s.logger.Info("session moved to another proxy",
+			slog.String("session", id),
+			slog.String("from", sess.proxy.Host),
+			slog.String("to", u.Host))
+		sess.jar, _ = cookiejar.New(nil)
+	}
+	sess.proxy = u
+}
+
+func (s *Sessions) jar(id string) http.CookieJar {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(id).jar
+}
+
+// End forgets the session when its chain is finished.
+func (s *Sessions) End(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+func (s *Sessions) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+func sessionID(ctx context.Context) string {
+	id, _ := ctx.Value(sessionKey{}).(string)
+	return id
+}
+
+// SessionTransport moves the session of the request from its header to the context, so the
+// switcher can pick the proxy of the session, and sends the cookies of the session jar.
+type SessionTransport struct {
+	Base     http.RoundTripper
+	Sessions *Sessions
+}
+
+func (t *SessionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := req.Header.Get(SessionHeader)
+	if id == "" {
+		return t.Base.RoundTrip(req)
+	}
+
+	// the request is changed in place, colly reads the proxy of the request from its context
+	*req = *req.WithContext(context.WithValue(req.Context(), sessionKey{}, id))
+	req.Header.Del(SessionHeader)
+
+	jar := t.Sessions.jar(id)
+	req.Header.Del("Cookie")
+	for _, cookie := range jar.Cookies(req.URL) {
+		req.AddCookie(cookie)
+	}
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if cookies := resp.Cookies(); len(cookies) > 0 {
+		jar.SetCookies(req.URL, cookies)
+	}
+	return resp, nil
+}
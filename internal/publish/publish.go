@@ -0,0 +1,75 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+)
+
+// Publisher sends the messages of the scrapper to the channels of the consumers.
+type Publisher interface {
+	Publish(ctx context.Context, channel string, message []byte) error
+}
+
+type Redis struct {
+	rdb redis.UniversalClient
+}
+
+func NewRedis(rdb redis.UniversalClient) *Redis {
+	return &Redis{rdb: rdb}
+}
+
+func (p *Redis) Publish(ctx context.Context, channel string, message []byte) error {
+	return p.rdb.Publish(ctx, channel, message).Err()
+}
+
+type line struct {
+	Channel string          `json:"channel"`
+	Message json.RawMessage `json:"message"`
+}
+
+// File writes the messages as JSON lines with their channels instead of publishing them.
+type File struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewFile creates the file at path, "-" is stdout.
+func NewFile(path string) (*File, error) {
+	if path == "-" {
+		return &File{w: os.Stdout}, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't create output file: %w", err)
+	}
+	return &File{w: f, closer: f}, nil
+}
+
+func (p *File) Publish(_ context.Context, channel string, message []byte) error {
+	b, err := sonic.Marshal(line{Channel: channel, Message: message})
+	if err != nil {
+		return fmt.Errorf("can't marshal line: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.w.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("can't write line: %w", err)
+	}
+	return nil
+}
+
+func (p *File) Close() error {
+	if p.closer == nil {
+		return nil
+	}
+	return p.closer.Close()
+}
@@ -0,0 +1,237 @@
+package quality
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+
+	"github.com/STTM-NSU/web-scrapper/internal/metrics"
+)
+
+const (
+	Title = "title"
+	Body  = "body"
+	Date  = "date"
+)
+
+var fields = []string{Title, Body, Date}
+
+const (
+	Firing   = "firing"
+	Resolved = "resolved"
+)
+
+type Config struct {
+	Window        time.Duration
+	CheckInterval time.Duration
+	// no alerts until the window has MinArticles articles
+	MinArticles int
+	// Thresholds is the minimal share of the articles with the field, by field
+	Thresholds map[string]float64
+	// a body shorter than MinBodyLength characters is counted as missing
+	MinBodyLength int
+	Samples       int
+	// Webhook gets the alerts as JSON in POST requests, no webhook if empty
+	Webhook        string
+	WebhookTimeout time.Duration
+}
+
+// Observation is the fields extracted from one article page.
+type Observation struct {
+	URL        string
+	Title      bool
+	BodyLength int
+	Date       bool
+}
+
+type Alert struct {
+	Source    string    `json:"source"`
+	Field     string    `json:"field"`
+	Status    string    `json:"status"`
+	Coverage  float64   `json:"coverage"`
+	Threshold float64   `json:"threshold"`
+	Articles  int       `json:"articles"`
+	Samples   []string  `json:"samples,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+type entry struct {
+	at      time.Time
+	url     string
+	missing map[string]bool
+}
+
+type source struct {
+	entries []entry
+	firing  map[string]bool
+}
+
+// Monitor keeps the coverage of the article fields of every source over a rolling window
+// and alerts in the logs, the metrics and the webhook when it drops below the thresholds,
+// with the urls of the latest articles missing the field.
+type Monitor struct {
+	mu      sync.Mutex
+	sources map[string]*source
+
+	cfg    Config
+	client *http.Client
+	logger *slog.Logger
+}
+
+func NewMonitor(cfg Config, logger *slog.Logger) *Monitor {
+	return &Monitor{
+		sources: make(map[string]*source),
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.WebhookTimeout},
+		logger:  logger,
+	}
+}
+
+func (m *Monitor) Observe(src string, o Observation) {
+	e := entry{
+		at:  time.Now(),
+		url: o.URL,
+		missing: map[string]bool{
+			Title: !o.Title,
+			Body:  o.BodyLength < m.cfg.MinBodyLength,
+			Date:  !o.Date,
+		},
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sources[src]
+	if !ok {
+		s = &source{firing: make(map[string]bool)}
+		m.sources[src] = s
+	}
+	s.entries = append(s.entries, e)
+}
+
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, alert := range m.check() {
+			m.alert(ctx, alert)
+		}
+	}
+}
+
+// check updates the coverage and returns the alerts that started or ended.
+func (m *Monitor) check() []Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var alerts []Alert
+	for name, s := range m.sources {
+		i := 0
+		for i < len(s.entries) && now.Sub(s.entries[i].at) > m.cfg.Window {
+			i++
+		}
+		s.entries = append(s.entries[:0], s.entries[i:]...)
+
+		for _, field := range fields {
+			var (
+				missing int
+				samples []string
+			)
+			for j := len(s.entries) - 1; j >= 0; j-- {
+				if !s.entries[j].missing[field] {
+					continue
+				}
+				missing++
+				if len(samples) < m.cfg.Samples {
+					samples = append(samples, s.entries[j].url)
+				}
+			}
+
+			coverage := 1.0
+			if len(s.entries) > 0 {
+				coverage = 1 - float64(missing)/float64(len(s.entries))
+			}
+			metrics.FieldCoverage.WithLabelValues(name, field).Set(coverage)
+
+			threshold := m.cfg.Thresholds[field]
+			firing := len(s.entries) >= m.cfg.MinArticles && coverage < threshold
+			if firing == s.firing[field] {
+				continue
+			}
+			s.firing[field] = firing
+			alert := Alert{
+				Source:    name,
+				Field:     field,
+				Status:    Resolved,
+				Coverage:  coverage,
+				Threshold: threshold,
+				Articles:  len(s.entries),
+				At:        now,
+			}
+			if firing {
+				alert.Status = Firing
+				alert.Samples = samples
+				metrics.QualityAlert.WithLabelValues(name, field).Set(1)
+			} else {
+				metrics.QualityAlert.WithLabelValues(name, field).Set(0)
+			}
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+func (m *Monitor) alert(ctx context.Context, alert Alert) {
+	attrs := []any{
+		slog.String("source", alert.Source),
+		slog.String("field", alert.Field),
+		slog.Float64("coverage", alert.Coverage),
+		slog.Float64("threshold", alert.Threshold),
+		slog.Int("articles", alert.Articles),
+	}
+	if alert.Status == Firing {
+		m.logger.Error("extraction coverage dropped", append(attrs, slog.Any("samples", alert.Samples))...)
+	} else {
+		m.logger.Info("extraction coverage recovered", attrs...)
+	}
+
+	if m.cfg.Webhook == "" {
+		return
+	}
+	if err := m.send(ctx, alert); err != nil {
+		m.logger.Error("can't send alert: "+err.Error(), slog.String("source", alert.Source), slog.String("field", alert.Field))
+	}
+}
+
+func (m *Monitor) send(ctx context.Context, alert Alert) error {
+	body, err := sonic.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("can't marshal alert: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.cfg.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("can't create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("can't post webhook: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
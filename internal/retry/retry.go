@@ -0,0 +1,117 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type Class int
+
+const (
+	Unknown Class = iota
+	Canceled
+	Throttled
+	Server
+	Proxy
+	Timeout
+	Network
+	NotFound
+	Client
+)
+
+func (c Class) String() string {
+	switch c {
+	case Canceled:
+		return "canceled"
+	case Throttled:
+		return "throttled"
+	case Server:
+		return "server"
+	case Proxy:
+		return "proxy"
+	case Timeout:
+		return "timeout"
+	case Network:
+		return "network"
+	case NotFound:
+		return "not_found"
+	case Client:
+		return "client"
+	default:
+		return "unknown"
+	}
+}
+
+// Retryable reports whether a fetch that failed with the class can succeed if repeated.
+func (c Class) Retryable() bool {
+	switch c {
+	case Throttled, Server, Proxy, Timeout, Network:
+		return true
+	default:
+		return false
+	}
+}
+
+// Classify maps the status code of a response and the error of a fetch to a Class.
+// Network errors are checked first, because there is no status for them.
+func Classify(status int, err error) Class {
+	if errors.Is(err, context.Canceled) {
+		return Canceled
+	}
+	// the client wraps every error in *url.Error, which is a net.Error itself
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "proxyconnect" {
+		return Proxy
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return Timeout
+	}
+	if netErr != nil || opErr != nil {
+		return Network
+	}
+
+	switch {
+	case status == http.StatusTooManyRequests:
+		return Throttled
+	case status == http.StatusBadGateway || status == http.StatusProxyAuthRequired:
+		return Proxy
+	case status >= http.StatusInternalServerError:
+		return Server
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return NotFound
+	case status >= http.StatusBadRequest:
+		return Client
+	default:
+		return Unknown
+	}
+}
+
+type Policy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// Backoff returns an exponential delay with full jitter before the attempt+1 try.
+func (p Policy) Backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, p.MaxDelay)
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(d))) + 1
+}
@@ -0,0 +1,106 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassify(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errors.New("connection refused"))}
+	proxyconnect := &net.OpError{Op: "proxyconnect", Net: "tcp", Err: errors.New("connection refused")}
+	wrap := func(err error) error { return &url.Error{Op: "Get", URL: "https://rus.rbc.ru", Err: err} }
+
+	tests := []struct {
+		name   string
+		status int
+		err    error
+		want   Class
+	}{
+		{"ok", http.StatusOK, nil, Unknown},
+		{"canceled", 0, context.Canceled, Canceled},
+		{"canceled in url error", 0, wrap(context.Canceled), Canceled},
+		{"deadline", 0, context.DeadlineExceeded, Timeout},
+		{"deadline in url error", 0, wrap(context.DeadlineExceeded), Timeout},
+		{"net timeout", 0, wrap(timeoutError{}), Timeout},
+		{"proxyconnect", 0, wrap(proxyconnect), Proxy},
+		{"refused", 0, wrap(refused), Network},
+		{"wrapped refused", 0, fmt.Errorf("can't fetch: %w", wrap(refused)), Network},
+		// a plain error in *url.Error is not a network error, though *url.Error is a net.Error
+		{"plain error in url error", 0, wrap(errors.New("no cassette")), Unknown},
+		{"too many requests", http.StatusTooManyRequests, nil, Throttled},
+		{"bad gateway", http.StatusBadGateway, nil, Proxy},
+		{"proxy auth", http.StatusProxyAuthRequired, nil, Proxy},
+		{"server", http.StatusServiceUnavailable, nil, Server},
+		{"not found", http.StatusNotFound, nil, NotFound},
+		{"gone", http.StatusGone, nil, NotFound},
+		{"forbidden", http.StatusForbidden, nil, Client},
+		{"error wins over status", http.StatusNotFound, wrap(refused), Network},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.status, tt.err); got != tt.want {
+				t.Errorf("Classify(%d, %v) = %s, want %s", tt.status, tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		class Class
+		want  bool
+	}{
+		{Unknown, false},
+		{Canceled, false},
+		{Throttled, true},
+		{Server, true},
+		{Proxy, true},
+		{Timeout, true},
+		{Network, true},
+		{NotFound, false},
+		{Client, false},
+	}
+	for _, tt := range tests {
+		if got := tt.class.Retryable(); got != tt.want {
+			t.Errorf("%s.Retryable() = %t, want %t", tt.class, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := Policy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		for range 100 {
+			if d := p.Backoff(tt.attempt); d <= 0 || d > tt.max {
+				t.Fatalf("Backoff(%d) = %s, want in (0, %s]", tt.attempt, d, tt.max)
+			}
+		}
+	}
+
+	if d := (Policy{}).Backoff(1); d != 0 {
+		t.Errorf("Backoff without delay = %s, want 0", d)
+	}
+}
@@ -0,0 +1,64 @@
+package revision
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Scrapper fetches the articles again and checks them with the tracker.
+type Scrapper interface {
+	Recheck(ctx context.Context, urls []string) error
+}
+
+// Rechecker fetches the articles of the source due for a check every interval.
+type Rechecker struct {
+	source   string
+	tracker  *Tracker
+	scrapper Scrapper
+	interval time.Duration
+	batch    int
+	logger   *slog.Logger
+}
+
+func NewRechecker(source string, tracker *Tracker, scrapper Scrapper, interval time.Duration, batch int, logger *slog.Logger) *Rechecker {
+	return &Rechecker{
+		source:   source,
+		tracker:  tracker,
+		scrapper: scrapper,
+		interval: interval,
+		batch:    batch,
+		logger:   logger,
+	}
+}
+
+func (r *Rechecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// a full batch means more may be due
+		for {
+			urls, err := r.tracker.Claim(ctx, r.source, r.batch)
+			if err != nil {
+				r.logger.Error(err.Error(), slog.String("source", r.source))
+				break
+			}
+			if len(urls) == 0 {
+				break
+			}
+			if err := r.scrapper.Recheck(ctx, urls); err != nil {
+				r.logger.Error("can't recheck articles: "+err.Error(), slog.String("source", r.source))
+			}
+			if len(urls) < r.batch || ctx.Err() != nil {
+				break
+			}
+		}
+	}
+}
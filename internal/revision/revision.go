@@ -0,0 +1,149 @@
+package revision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// trackScript starts tracking the article unless it is tracked already
+	trackScript = redis.NewScript(`
+if redis.call("exists", KEYS[2]) == 1 then
+	return 0
+end
+redis.call("hset", KEYS[2], "hash", ARGV[2], "revision", 0, "checks", 0, "published", ARGV[3])
+redis.call("pexpire", KEYS[2], ARGV[5])
+redis.call("zadd", KEYS[1], ARGV[4], ARGV[1])
+return 1`)
+
+	// claimScript takes the due urls and moves them claimTimeout forward, so the other replicas
+	// don't check them at the same time and they are checked again if this one dies.
+	// The article keys are in the slot of the due key by the hash tag.
+	claimScript = redis.NewScript(`
+local urls = redis.call("zrangebyscore", KEYS[1], "-inf", ARGV[1], "limit", 0, ARGV[2])
+local res = {}
+for _, url in ipairs(urls) do
+	if redis.call("exists", ARGV[4] .. url) == 1 then
+		redis.call("zadd", KEYS[1], ARGV[3], url)
+		table.insert(res, url)
+	else
+		redis.call("zrem", KEYS[1], url)
+	end
+end
+return res`)
+
+	// checkScript compares the hash, counts the revision if it changed and schedules
+	// the next check, ARGV[3] is its time or 0 if it was the last one
+	checkScript = redis.NewScript(`
+local state = redis.call("hmget", KEYS[2], "hash", "revision")
+if not state[1] then
+	redis.call("zrem", KEYS[1], ARGV[1])
+	return {-1, 0}
+end
+local revision = tonumber(state[2])
+local changed = 0
+if state[1] ~= ARGV[2] then
+	revision = redis.call("hincrby", KEYS[2], "revision", 1)
+	redis.call("hset", KEYS[2], "hash", ARGV[2])
+	changed = 1
+end
+redis.call("hincrby", KEYS[2], "checks", 1)
+if ARGV[3] == "0" then
+	redis.call("zrem", KEYS[1], ARGV[1])
+else
+	redis.call("zadd", KEYS[1], ARGV[3], ARGV[1])
+end
+return {revision, changed}`)
+)
+
+const claimTimeout = 10 * time.Minute
+
+// Tracker keeps the content hashes of the published articles in Redis and plans their checks
+// at the delays after the publication. The keys of a source share the hash tag to be in the same
+// slot of a cluster.
+type Tracker struct {
+	rdb       redis.UniversalClient
+	keyPrefix string
+	delays    []time.Duration
+}
+
+func NewTracker(rdb redis.UniversalClient, keyPrefix string, delays []time.Duration) *Tracker {
+	return &Tracker{
+		rdb:       rdb,
+		keyPrefix: keyPrefix,
+		delays:    delays,
+	}
+}
+
+func (t *Tracker) dueKey(source string) string {
+	return t.keyPrefix + ":{" + source + "}:due"
+}
+
+func (t *Tracker) articlePrefix(source string) string {
+	return t.keyPrefix + ":{" + source + "}:article:"
+}
+
+// next returns the first check after now, the zero time if all of them are past.
+func (t *Tracker) next(published, now time.Time) time.Time {
+	for _, d := range t.delays {
+		if at := published.Add(d); at.After(now) {
+			return at
+		}
+	}
+	return time.Time{}
+}
+
+// Track starts tracking the article published at published with the content hash. Articles
+// seen before and the ones older than the last delay, from the backfill, are not tracked.
+func (t *Tracker) Track(ctx context.Context, source, url, hash string, published time.Time) error {
+	next := t.next(published, time.Now())
+	if next.IsZero() {
+		return nil
+	}
+	ttl := published.Add(t.delays[len(t.delays)-1] + claimTimeout).Sub(time.Now())
+	err := trackScript.Run(ctx, t.rdb, []string{t.dueKey(source), t.articlePrefix(source) + url},
+		url, hash, published.Unix(), next.Unix(), ttl.Milliseconds()).Err()
+	if err != nil {
+		return fmt.Errorf("can't track article: %w", err)
+	}
+	return nil
+}
+
+// Claim returns up to n urls of the source due for a check.
+func (t *Tracker) Claim(ctx context.Context, source string, n int) ([]string, error) {
+	now := time.Now()
+	urls, err := claimScript.Run(ctx, t.rdb, []string{t.dueKey(source)},
+		now.Unix(), n, now.Add(claimTimeout).Unix(), t.articlePrefix(source)).StringSlice()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("can't claim articles: %w", err)
+	}
+	return urls, nil
+}
+
+// Check records the content hash of the article fetched again and returns its revision
+// and whether it changed. The revision is -1 if the article is not tracked anymore.
+func (t *Tracker) Check(ctx context.Context, source, url, hash string) (int, bool, error) {
+	key := t.articlePrefix(source) + url
+	published, err := t.rdb.HGet(ctx, key, "published").Int64()
+	if errors.Is(err, redis.Nil) {
+		return -1, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("can't get article: %w", err)
+	}
+	var next int64
+	if at := t.next(time.Unix(published, 0), time.Now()); !at.IsZero() {
+		next = at.Unix()
+	}
+
+	res, err := checkScript.Run(ctx, t.rdb, []string{t.dueKey(source), key}, url, hash, strconv.FormatInt(next, 10)).Int64Slice()
+	if err != nil {
+		return 0, false, fmt.Errorf("can't check article: %w", err)
+	}
+	return int(res[0]), res[1] == 1, nil
+}
@@ -0,0 +1,157 @@
+package ria
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/STTM-NSU/web-scrapper/internal/quality"
+	"github.com/STTM-NSU/web-scrapper/internal/textnorm"
+)
+
+const (
+	dateSelector = "div.article__info-date"
+	textSelector = "div.article__text"
+)
+
+// titleSelectors are tried in order, the first one found is the title
+var titleSelectors = []string{"h1.article__title", "div.article__title"}
+
+var errNoArticle = errors.New("not an article")
+
+var articleDay = regexp.MustCompile(`^https://([a-z]+\.)?ria\.ru/(\d{8})/`)
+
+var moscow = time.FixedZone("MSK", 3*60*60)
+
+// cleaner removes the read also widgets, quote and photo cards and the subscription calls from the texts.
+var cleaner = textnorm.NewCleaner(
+	[]string{"div.article__article", "div.article__quote", "div.article__photo", "div.media", "figcaption"},
+	[]*regexp.Regexp{
+		regexp.MustCompile(`(?i)(читайте|смотрите) также:?.*$`),
+		regexp.MustCompile(`(?i)подписывайтесь на .*$`),
+	},
+)
+
+// paragraph is a text block of the article, raw and without the boilerplate elements.
+type paragraph struct {
+	raw   string
+	clean string
+}
+
+// article is extracted from one page at once, so it is published only when it is complete.
+type article struct {
+	url        string
+	title      paragraph
+	date       time.Time
+	paragraphs []paragraph
+	// dateErr is why the date on the page can't be parsed
+	dateErr error
+	// selectors that matched the fields
+	selectors map[string]string
+}
+
+// extractArticle extracts the article from the page, errNoArticle if url is not the one of an article.
+// The fields no selector matched are left empty, so that markup changes show up in the validation.
+func extractArticle(url string, page *goquery.Selection) (article, error) {
+	if !articleDay.MatchString(url) {
+		return article{}, errNoArticle
+	}
+	a := article{url: url, selectors: make(map[string]string)}
+
+	for _, selector := range titleSelectors {
+		if title := page.Find(selector).First(); title.Length() > 0 {
+			a.title = newParagraph(title)
+			a.selectors[quality.Title] = selector
+			break
+		}
+	}
+	if dates := page.Find(dateSelector); dates.Length() > 0 {
+		a.date, a.dateErr = parseDate(dates.First().Text())
+		a.selectors[quality.Date] = dateSelector
+	}
+	if texts := page.Find(textSelector); texts.Length() > 0 {
+		texts.Each(func(_ int, s *goquery.Selection) {
+			a.paragraphs = append(a.paragraphs, newParagraph(s))
+		})
+		a.selectors[quality.Body] = textSelector
+	}
+	return a, nil
+}
+
+func newParagraph(s *goquery.Selection) paragraph {
+	return paragraph{raw: s.Text(), clean: cleaner.Block(s)}
+}
+
+func parseDate(text string) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	if len(text) < 16 {
+		text += strings.Repeat(" ", 16-len(text))
+	}
+	return time.Parse("15:04 02.01.2006", text[:16])
+}
+
+// validate returns the selector of the first required field that is missing and why,
+// "" if the article is complete.
+func (a article) validate() (string, error) {
+	switch {
+	case len(a.selectors) == 0:
+		// the markup changed or the page is not an article after all
+		return "all", errors.New("no selector matched")
+	case a.dateErr != nil:
+		return dateSelector, fmt.Errorf("can't parse date: %w", a.dateErr)
+	case a.date.IsZero():
+		return dateSelector, errors.New("no date")
+	case cleaner.Paragraph(a.title.clean) == "":
+		return strings.Join(titleSelectors, ", "), errors.New("no title")
+	case len(a.paragraphs) == 0:
+		return textSelector, errors.New("no text")
+	default:
+		return "", nil
+	}
+}
+
+func (a article) observation() quality.Observation {
+	clean := make([]string, len(a.paragraphs))
+	for i, p := range a.paragraphs {
+		clean[i] = p.clean
+	}
+	return quality.Observation{
+		URL:        a.url,
+		Title:      cleaner.Paragraph(a.title.clean) != "",
+		BodyLength: utf8.RuneCountInString(cleaner.Join(clean)),
+		Date:       a.dateErr == nil && !a.date.IsZero(),
+	}
+}
+
+// hash is the hash of the content of the article.
+func (a article) hash() string {
+	_, clean := a.texts()
+	sum := sha256.Sum256([]byte(clean))
+	return hex.EncodeToString(sum[:])
+}
+
+// published returns the date of the article, it is on the page in the Moscow time.
+func (a article) published() time.Time {
+	return time.Date(a.date.Year(), a.date.Month(), a.date.Day(), a.date.Hour(), a.date.Minute(), 0, 0, moscow)
+}
+
+// texts returns the raw text and the cleaned one, the title goes first.
+func (a article) texts() (string, string) {
+	paragraphs := a.paragraphs
+	if a.title.raw != "" {
+		paragraphs = append([]paragraph{a.title}, paragraphs...)
+	}
+	raw := make([]string, len(paragraphs))
+	clean := make([]string, len(paragraphs))
+	for i, p := range paragraphs {
+		raw[i], clean[i] = p.raw, p.clean
+	}
+	return strings.Join(raw, " "), cleaner.Join(clean)
+}
@@ -0,0 +1,62 @@
+package ria
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Extraction is the article of a page as it would be published, with the selectors that matched its fields.
+type Extraction struct {
+	URL       string            `json:"url"`
+	Title     string            `json:"title"`
+	Date      *time.Time        `json:"date,omitempty"`
+	DateError string            `json:"date_error,omitempty"`
+	Text      string            `json:"text"`
+	RawText   string            `json:"raw_text"`
+	Selectors map[string]string `json:"selectors"`
+	// Invalid is why the article would not be published, "" if it would be
+	Invalid   string `json:"invalid,omitempty"`
+	Partition int    `json:"partition"`
+	Channel   string `json:"channel"`
+}
+
+// Extract runs the extraction of the scrapper over the page of url, nothing is fetched or published.
+func Extract(cfg Config, url string, page io.Reader) (Extraction, error) {
+	doc, err := goquery.NewDocumentFromReader(page)
+	if err != nil {
+		return Extraction{}, fmt.Errorf("can't parse page: %w", err)
+	}
+	a, err := extractArticle(url, doc.Selection)
+	if err != nil {
+		return Extraction{}, err
+	}
+	partition, err := getPartition(url, cfg.PartitionsCount)
+	if err != nil {
+		return Extraction{}, fmt.Errorf("can't get partition: %w", err)
+	}
+
+	raw, clean := a.texts()
+	res := Extraction{
+		URL:       url,
+		Title:     cleaner.Paragraph(a.title.clean),
+		Text:      clean,
+		RawText:   raw,
+		Selectors: a.selectors,
+		Partition: partition,
+		Channel:   cfg.RedisChanelName + ":" + strconv.Itoa(partition),
+	}
+	if !a.date.IsZero() {
+		res.Date = &a.date
+	}
+	if a.dateErr != nil {
+		res.DateError = a.dateErr.Error()
+	}
+	if _, err := a.validate(); err != nil {
+		res.Invalid = err.Error()
+	}
+	return res, nil
+}
@@ -0,0 +1,47 @@
+package ria
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Recheck fetches the tracked articles again and publishes the changed ones with their revision
+// to the updated channels. The urls are fetched by day, the day is in every article url.
+func (s *Scrapper) Recheck(ctx context.Context, urls []string) error {
+	var days []string
+	byDay := make(map[string][]string)
+	for _, u := range urls {
+		m := articleDay.FindStringSubmatch(u)
+		if m == nil {
+			s.logger.Error("no day in url", slog.String("url", u))
+			continue
+		}
+		if _, ok := byDay[m[2]]; !ok {
+			days = append(days, m[2])
+		}
+		byDay[m[2]] = append(byDay[m[2]], u)
+	}
+
+	for _, day := range days {
+		r := newRun(day, nil, s.publisher, s.config().RedisChanelName+UpdatedSuffix)
+		r.recheck = true
+		c, err := s.newCollector(ctx, r, false)
+		if err != nil {
+			return err
+		}
+		for _, u := range byDay[day] {
+			if err := s.visit(c, r, u); err != nil {
+				s.logger.Error("can't recheck url", slog.String("url", u), slog.String("error", err.Error()))
+			}
+		}
+		s.wait(ctx, c, r)
+		s.endSessions(r)
+
+		s.logger.Info("rechecked",
+			slog.String("day", day),
+			slog.Int("urls", len(byDay[day])),
+			slog.Int("updated", r.published),
+			slog.Int("failed", r.failed))
+	}
+	return nil
+}
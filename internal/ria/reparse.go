@@ -0,0 +1,81 @@
+package ria
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/vhlebnikov/colly/v2"
+
+	"github.com/STTM-NSU/web-scrapper/internal/archive"
+	"github.com/STTM-NSU/web-scrapper/internal/publish"
+)
+
+// UpdatedSuffix is added to the channel name for the articles published again after they changed.
+const UpdatedSuffix = "_updated"
+
+// Reparse runs the current extraction over the archived articles of the days from..to, both
+// included, and publishes them to the updated channels of publisher. Only the latest response
+// of every url is used and nothing is fetched from the network.
+func (s *Scrapper) Reparse(ctx context.Context, reader *archive.Reader, from, to time.Time, publisher publish.Publisher) error {
+	first, last := from.Format("20060102"), to.Format("20060102")
+	inRange := func(u string) bool {
+		m := articleDay.FindStringSubmatch(u)
+		return m != nil && m[2] >= first && m[2] <= last
+	}
+
+	latest := make(map[string]string)
+	err := reader.Scan(ctx, func(id string, resp archive.Response) error {
+		if inRange(resp.URL) && resp.Status == http.StatusOK {
+			latest[resp.URL] = id
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("can't scan archive: %w", err)
+	}
+	s.logger.Info("start reparse", slog.String("from", first), slog.String("to", last), slog.Int("articles", len(latest)))
+
+	r := newRun(first+"-"+last, nil, publisher, s.config().RedisChanelName+UpdatedSuffix)
+	r.reparse = true
+	transport := archive.NewTransport()
+	c := colly.NewCollector(colly.AllowURLRevisit())
+	c.Context = ctx
+	c.WithTransport(transport)
+	s.onArticle(ctx, c, r)
+	c.OnError(func(_ *colly.Response, _ error) {
+		r.mu.Lock()
+		r.failed++
+		r.mu.Unlock()
+	})
+
+	timeStart := time.Now()
+	err = reader.Scan(ctx, func(id string, resp archive.Response) error {
+		if latest[resp.URL] != id {
+			return nil
+		}
+		transport.Put(resp)
+		// reparsed articles are in the archive already
+		r.putArchiveID(resp.URL, id)
+		// the collector is synchronous, the article is published when Request returns
+		if err := c.Request(http.MethodGet, resp.URL, nil, nil, nil); err != nil {
+			r.takeArchiveID(resp.URL)
+			s.logger.Error("can't reparse article "+err.Error(), slog.String("url", resp.URL))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("can't scan archive: %w", err)
+	}
+
+	s.logger.Info("reparsed",
+		slog.String("from", first),
+		slog.String("to", last),
+		slog.Int("articles", len(latest)),
+		slog.Int("count", r.published),
+		slog.Int("failed", r.failed),
+		slog.String("duration", time.Since(timeStart).String()))
+	return nil
+}
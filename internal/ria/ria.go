@@ -5,80 +5,455 @@ import (
 	"fmt"
 	"hash/fnv"
 	"log/slog"
+	"net/http"
 	"net/url"
 	"regexp"
-	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bytedance/sonic"
-	"github.com/redis/go-redis/v9"
 	"github.com/vhlebnikov/colly/v2"
 
+	"github.com/STTM-NSU/web-scrapper/internal/archive"
+	"github.com/STTM-NSU/web-scrapper/internal/breaker"
+	"github.com/STTM-NSU/web-scrapper/internal/cassette"
+	"github.com/STTM-NSU/web-scrapper/internal/checkpoint"
+	"github.com/STTM-NSU/web-scrapper/internal/concurrency"
+	"github.com/STTM-NSU/web-scrapper/internal/deadletter"
+	"github.com/STTM-NSU/web-scrapper/internal/dedup"
+	"github.com/STTM-NSU/web-scrapper/internal/metrics"
 	"github.com/STTM-NSU/web-scrapper/internal/model"
 	"github.com/STTM-NSU/web-scrapper/internal/proxy"
+	"github.com/STTM-NSU/web-scrapper/internal/publish"
+	"github.com/STTM-NSU/web-scrapper/internal/quality"
+	"github.com/STTM-NSU/web-scrapper/internal/retry"
+	"github.com/STTM-NSU/web-scrapper/internal/revision"
 )
 
+const Source = "ria"
+
+const sessionKey = "session"
+
+type Config struct {
+	RedisChanelName   string
+	PartitionsCount   int
+	DefaultRetryAfter time.Duration
+	Retry             retry.Policy
+	MaxConcurrency    int
+	StickySessions    bool
+	DrainTimeOut      time.Duration
+	// ProxyBlameWindow is how recent a response through another proxy has to be for a proxy error to delete the proxy
+	ProxyBlameWindow time.Duration
+	// UserAgent is the colly one if empty
+	UserAgent          string
+	RequestDelay       time.Duration
+	RequestRandomDelay time.Duration
+	RequestTimeOut     time.Duration
+	// CassetteMode and CassetteDir are passed to cassette.Wrap
+	CassetteMode string
+	CassetteDir  string
+}
+
 type Scrapper struct {
-	rdb           *redis.Client
+	publisher     publish.Publisher
 	logger        *slog.Logger
 	proxySwitcher *proxy.MyRoundRobinSwitcher
-	articles      sync.Map
-	articlesDate  sync.Map
+	deadLetter    *deadletter.Queue
+	concurrency   *concurrency.Controller
+	breaker       *breaker.Breaker
+	accountant    *proxy.Accountant
+	checkpoints   *checkpoint.Store
+	archive       *archive.Writer
+	quality       *quality.Monitor
+	dedup         *dedup.Index
+	revisions     *revision.Tracker
+
+	cfgMu sync.RWMutex
+	cfg   Config
+}
+
+type run struct {
+	day       string
+	publisher publish.Publisher
+	// channel is the prefix of the partition channels the articles are published to
+	channel string
+	// id tells the sessions of the runs of the same day apart
+	id        uint64
+	mu        sync.Mutex
+	published int
+	failed    int
+	sessions  []string
+
+	// recheck runs publish only the articles that changed since they were tracked
+	recheck bool
+	// reparse runs publish the articles extracted again from the archive
+	reparse bool
+
+	// draining is set on shutdown, no new pages are requested after that
+	draining      atomic.Bool
+	skip          map[string]struct{}
+	publishedURLs []string
+	unfinished    []string
+
+	// the colly context is shared by the whole chain of the day, so the state of a page is kept
+	// here by url and deleted as soon as the page is done with
+	archiveIDs map[string]string
+	attempts   map[string]int
+
+	// retries are waiting for their backoff
+	retries []pendingRetry
+	// drained is closed when the day starts draining
+	drained   chan struct{}
+	drainOnce sync.Once
+}
+
+type pendingRetry struct {
+	response *colly.Response
+	class    retry.Class
+	attempts int
+	err      error
+	at       time.Time
+}
+
+var runs atomic.Uint64
+
+func newRun(day string, published []string, publisher publish.Publisher, channel string) *run {
+	r := &run{
+		day:        day,
+		id:         runs.Add(1),
+		publisher:  publisher,
+		channel:    channel,
+		skip:       make(map[string]struct{}, len(published)),
+		archiveIDs: make(map[string]string),
+		attempts:   make(map[string]int),
+		drained:    make(chan struct{}),
+	}
+	for _, u := range published {
+		r.skip[u] = struct{}{}
+	}
+	return r
+}
+
+func (r *run) addUnfinished(u string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unfinished = append(r.unfinished, u)
+}
+
+// drain stops new pages from being requested and the retries from waiting.
+func (r *run) drain() {
+	r.draining.Store(true)
+	r.drainOnce.Do(func() {
+		close(r.drained)
+	})
+}
+
+func (r *run) putArchiveID(u, id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.archiveIDs[u] = id
+}
+
+// takeArchiveID returns the archive record id of the response of u and forgets it.
+func (r *run) takeArchiveID(u string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := r.archiveIDs[u]
+	delete(r.archiveIDs, u)
+	return id
+}
+
+// attempt counts a failed attempt to fetch u and returns the number of the attempts so far.
+func (r *run) attempt(u string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts[u]++
+	return r.attempts[u]
+}
 
-	redisChanelName string
-	partitionsCount int
+func (r *run) forgetAttempts(u string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.attempts, u)
 }
 
-func NewScrapper(rdb *redis.Client,
+func NewScrapper(publisher publish.Publisher,
 	logger *slog.Logger,
 	proxySwitcher *proxy.MyRoundRobinSwitcher,
-	redisChanelName string,
-	partitionsCount int) *Scrapper {
+	deadLetter *deadletter.Queue,
+	concurrencyController *concurrency.Controller,
+	circuitBreaker *breaker.Breaker,
+	accountant *proxy.Accountant,
+	checkpoints *checkpoint.Store,
+	archive *archive.Writer,
+	qualityMonitor *quality.Monitor,
+	dedupIndex *dedup.Index,
+	revisions *revision.Tracker,
+	cfg Config) *Scrapper {
 	return &Scrapper{
-		rdb:             rdb,
-		logger:          logger,
-		proxySwitcher:   proxySwitcher,
-		redisChanelName: redisChanelName,
-		partitionsCount: partitionsCount,
+		publisher:     publisher,
+		logger:        logger,
+		proxySwitcher: proxySwitcher,
+		deadLetter:    deadLetter,
+		concurrency:   concurrencyController,
+		breaker:       circuitBreaker,
+		accountant:    accountant,
+		checkpoints:   checkpoints,
+		archive:       archive,
+		quality:       qualityMonitor,
+		dedup:         dedupIndex,
+		revisions:     revisions,
+		cfg:           cfg,
 	}
 }
 
-func (s *Scrapper) Scrap(ctx context.Context, day string) error {
+func (s *Scrapper) config() Config {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.cfg
+}
 
-	var counter int
-	var mutex sync.Mutex
+// SetConfig changes the config at runtime, the collector settings are applied from the next day.
+func (s *Scrapper) SetConfig(cfg Config) {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+	s.cfg = cfg
+}
 
+// Scrap crawls the day. On shutdown, when ctx is canceled, the pages in flight get DrainTimeOut
+// to finish, and the published and unfinished urls are saved as the checkpoint of the day,
+// so the next Scrap of the day continues from it.
+func (s *Scrapper) Scrap(ctx context.Context, day string) error {
 	defer func() {
 		if r := recover(); r != nil {
 			s.logger.Error("Recovered in ria.Scrap", slog.Any("panic", r))
 		}
 	}()
 
+	var published, unfinished []string
+	if s.checkpoints != nil {
+		var err error
+		if published, unfinished, err = s.checkpoints.Load(ctx, Source, day); err != nil {
+			return err
+		}
+	}
+	if len(published) > 0 || len(unfinished) > 0 {
+		s.logger.Info("continue day from checkpoint",
+			slog.String("day", day),
+			slog.Int("published", len(published)),
+			slog.Int("unfinished", len(unfinished)))
+	}
+
+	// the collector has its own context, so pages in flight are not canceled with ctx at once
+	workCtx, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+
+	r := newRun(day, published, s.publisher, s.config().RedisChanelName)
+	c, err := s.newCollector(workCtx, r, true)
+	if err != nil {
+		return err
+	}
+
+	date, err := time.Parse("20060102", day)
+	if err != nil {
+		s.logger.Error("bad date " + err.Error())
+	}
+	timeStart := time.Now()
+	s.logger.Info("start scrapping day", slog.Time("date", date))
+	// the live and backfill days are scraped at the same time
+	metrics.CurrentDay.WithLabelValues(Source, concurrency.LaneFrom(ctx).String()).Set(float64(date.Unix()))
+	if err := s.visit(c, r, "https://ria.ru/"+day+"/"); err != nil {
+		return fmt.Errorf("can't start scrapping: %w", err)
+	}
+	for _, u := range unfinished {
+		if err := s.visit(c, r, u); err != nil {
+			s.logger.Error("can't visit unfinished url", slog.String("url", u), slog.String("error", err.Error()))
+		}
+	}
+
+	finished := make(chan struct{})
+	go s.drain(ctx, r, cancelWork, finished)
+	s.wait(workCtx, c, r)
+	close(finished)
+	s.endSessions(r)
+
+	if r.draining.Load() {
+		return s.interrupt(context.WithoutCancel(ctx), r, time.Since(timeStart))
+	}
+	if s.checkpoints != nil {
+		if err := s.checkpoints.Clear(ctx, Source, day); err != nil {
+			s.logger.Error(err.Error())
+		}
+	}
+
+	duration := time.Now().Sub(timeStart).String()
+	doneMessage, err := sonic.Marshal(model.DonePayload{
+		Date:     date.Format("2006-01-02T15:00:00"),
+		Count:    r.published,
+		Failed:   r.failed,
+		Duration: duration,
+	})
+
+	if err != nil {
+		return fmt.Errorf("can't marshal done message: %w", err)
+	}
+	if err := s.publisher.Publish(ctx, s.config().RedisChanelName+"_day_done", doneMessage); err != nil {
+		s.logger.Error("can't publish day done: " + err.Error())
+	}
+	s.logger.Info("scraped",
+		slog.String("date", date.Format("02.01.2006")),
+		slog.Int("count", r.published),
+		slog.Int("failed", r.failed),
+		slog.Int("concurrency", s.concurrency.Limit()),
+		slog.String("duration", duration))
+
+	return nil
+}
+
+// drain stops the run when ctx is canceled and cancels the pages still in flight after DrainTimeOut.
+func (s *Scrapper) drain(ctx context.Context, r *run, cancelWork context.CancelFunc, finished <-chan struct{}) {
+	select {
+	case <-finished:
+		return
+	case <-ctx.Done():
+	}
+
+	r.drain()
+	s.logger.Info("draining day", slog.String("day", r.day), slog.Duration("timeout", s.config().DrainTimeOut))
+
+	t := time.NewTimer(s.config().DrainTimeOut)
+	defer t.Stop()
+	select {
+	case <-finished:
+	case <-t.C:
+		s.logger.Warn("drain timed out, canceling pages in flight", slog.String("day", r.day))
+		cancelWork()
+	}
+}
+
+// interrupt saves the checkpoint of the drained run.
+func (s *Scrapper) interrupt(ctx context.Context, r *run, duration time.Duration) error {
+	r.mu.Lock()
+	published := append([]string{}, r.publishedURLs...)
+	unfinished := append([]string{}, r.unfinished...)
+	r.mu.Unlock()
+
+	if s.checkpoints != nil {
+		if err := s.checkpoints.Save(ctx, Source, r.day, published, unfinished); err != nil {
+			return fmt.Errorf("day %s interrupted: %w", r.day, err)
+		}
+	}
+	s.logger.Warn("day interrupted by shutdown",
+		slog.String("day", r.day),
+		slog.Int("published", r.published),
+		slog.Int("failed", r.failed),
+		slog.Int("unfinished", len(unfinished)),
+		slog.Any("unfinished urls", unfinished),
+		slog.Duration("duration", duration))
+
+	return fmt.Errorf("day %s interrupted by shutdown", r.day)
+}
+
+// Redrive fetches the urls of the day again. Only listing pages are crawled further,
+// so articles linked from the redriven articles are not published twice.
+// It returns the urls that were not fetched, because of the shutdown or an error.
+func (s *Scrapper) Redrive(ctx context.Context, day string, urls []string) ([]string, error) {
+	r := newRun(day, nil, s.publisher, s.config().RedisChanelName)
+	c, err := s.newCollector(ctx, r, false)
+	if err != nil {
+		return urls, err
+	}
+
+	for _, u := range urls {
+		if err := s.visit(c, r, u); err != nil {
+			s.logger.Error("can't redrive url", slog.String("url", u), slog.String("error", err.Error()))
+			r.addUnfinished(u)
+		}
+	}
+	s.wait(ctx, c, r)
+	s.endSessions(r)
+
+	s.logger.Info("redrived",
+		slog.String("day", day),
+		slog.Int("urls", len(urls)),
+		slog.Int("count", r.published),
+		slog.Int("failed", r.failed),
+		slog.Int("unfinished", len(r.unfinished)))
+
+	return r.unfinished, nil
+}
+
+// visit starts a crawl chain from u. With sticky sessions all the listing pages
+// of the chain go through the same proxy.
+func (s *Scrapper) visit(c *colly.Collector, r *run, u string) error {
+	ctx := colly.NewContext()
+	if s.config().StickySessions {
+		r.mu.Lock()
+		id := fmt.Sprintf("%s:%s:%d:%d", Source, r.day, r.id, len(r.sessions))
+		r.sessions = append(r.sessions, id)
+		r.mu.Unlock()
+		ctx.Put(sessionKey, id)
+	}
+	return c.Request(http.MethodGet, u, nil, ctx, nil)
+}
+
+func (s *Scrapper) endSessions(r *run) {
+	for _, id := range r.sessions {
+		s.proxySwitcher.Sessions().End(id)
+	}
+}
+
+func (s *Scrapper) newCollector(ctx context.Context, r *run, followArticles bool) (*colly.Collector, error) {
 	c := colly.NewCollector(
 		colly.URLFilters(
-			regexp.MustCompile(`https://([a-z]+\.)?ria\.ru/`+day+`+[^?]`),
-			regexp.MustCompile(`https://ria\.ru/services/`+day+`+`),
+			regexp.MustCompile(`https://([a-z]+\.)?ria\.ru/`+r.day+`+[^?]`),
+			regexp.MustCompile(`https://ria\.ru/services/`+r.day+`+`),
 		),
 		colly.Async(true),
 	)
 	c.Context = ctx
+	cfg := s.config()
+	if cfg.UserAgent != "" {
+		c.UserAgent = cfg.UserAgent
+	}
+	c.SetRequestTimeout(cfg.RequestTimeOut)
 
+	// requests are paced by the proxy switcher for every proxy and host separately,
+	// the number of requests in flight is adjusted by the concurrency controller
 	err := c.Limit(&colly.LimitRule{
 		DomainGlob:  "*",
-		Parallelism: runtime.GOMAXPROCS(-1),
-		Delay:       100 * time.Millisecond,
-		RandomDelay: 50 * time.Millisecond,
+		Parallelism: cfg.MaxConcurrency,
+		Delay:       cfg.RequestDelay,
+		RandomDelay: cfg.RequestRandomDelay,
 	})
 	if err != nil {
-		return fmt.Errorf("can't set limit %w", err)
+		return nil, fmt.Errorf("can't set limit %w", err)
 	}
 
-	c.SetProxyFunc(s.proxySwitcher.GetProxy)
+	c.WithTransport(&proxy.SessionTransport{
+		Base: &breaker.Transport{
+			Base: &concurrency.Transport{
+				Base: &proxy.AccountingTransport{
+					Base: cassette.Wrap(&http.Transport{
+						Proxy:             s.proxySwitcher.GetProxy,
+						DisableKeepAlives: true,
+					}, cfg.CassetteMode, cfg.CassetteDir),
+					Accountant: s.accountant,
+				},
+				Controller: s.concurrency,
+			},
+			Breaker: s.breaker,
+		},
+		Sessions: s.proxySwitcher.Sessions(),
+	})
 
 	c.OnHTML("a[href]", func(e *colly.HTMLElement) {
+		if !followArticles && !isListing(e.Request.URL) {
+			return
+		}
 		link := e.Request.AbsoluteURL(e.Attr("href"))
 		if link != "" && !strings.Contains(link, "?") && !strings.Contains(link, "#") {
 			err := e.Request.Visit(link)
@@ -88,53 +463,7 @@ func (s *Scrapper) Scrap(ctx context.Context, day string) error {
 		}
 	})
 
-	c.OnHTML("div.article__info-date", func(e *colly.HTMLElement) {
-		date, err := time.Parse("15:04 02.01.2006", e.Text[:16])
-
-		if err != nil {
-			fmt.Println(err)
-		}
-		if _, ok := s.articlesDate.Load(e.Request.URL.String()); ok {
-			s.logger.Error("duplicate url", slog.String("url", e.Request.URL.String()))
-		}
-		s.articlesDate.Store(e.Request.URL.String(), date)
-
-	})
-
-	c.OnHTML("div.article__title", func(e *colly.HTMLElement) {
-		if text, ok := s.articles.Load(e.Request.URL.String()); ok {
-			s.articles.Store(e.Request.URL.String(), append(text.([]string), e.Text))
-		} else {
-			s.articles.Store(e.Request.URL.String(), []string{e.Text})
-		}
-	})
-
-	c.OnHTML("h1.article__title", func(e *colly.HTMLElement) {
-		if text, ok := s.articles.Load(e.Request.URL.String()); ok {
-			s.articles.Store(e.Request.URL.String(), append(text.([]string), e.Text))
-		} else {
-			s.articles.Store(e.Request.URL.String(), []string{e.Text})
-		}
-	})
-
-	c.OnHTML("div.article__text", func(e *colly.HTMLElement) {
-		if text, ok := s.articles.Load(e.Request.URL.String()); ok {
-			s.articles.Store(e.Request.URL.String(), append(text.([]string), e.Text))
-		} else {
-			s.articles.Store(e.Request.URL.String(), []string{e.Text})
-		}
-	})
-	c.OnHTML("div.recommend__place", func(e *colly.HTMLElement) {
-
-		err := s.sendMessage(ctx, e.Request.URL.String())
-		mutex.Lock()
-		counter++
-		mutex.Unlock()
-		if err != nil {
-			s.logger.Error("ria sendMessage: " + err.Error())
-			return
-		}
-	})
+	s.onArticle(ctx, c, r)
 
 	c.OnHTML("div.list-more", func(e *colly.HTMLElement) {
 		link := e.Request.AbsoluteURL(e.Attr("href"))
@@ -155,105 +484,354 @@ func (s *Scrapper) Scrap(ctx context.Context, day string) error {
 				s.logger.Error("can't visit article: " + err.Error())
 				return
 			}
+		} else if id := e.Request.Ctx.Get(sessionKey); id != "" {
+			// the listing has no more pages, so the chain is finished
+			s.proxySwitcher.Sessions().End(id)
 		}
 	})
 	c.OnRequest(func(request *colly.Request) {
-		// if !strings.Contains(request.URL.String(), "more.html") {
-		//
-		//	fmt.Println(request.ProxyURL, request.URL, counter)
-		// }
-
+		s.retryDue(ctx, r)
+		if r.draining.Load() {
+			r.addUnfinished(request.URL.String())
+			request.Abort()
+			return
+		}
+		if id := request.Ctx.Get(sessionKey); id != "" && isListing(request.URL) {
+			request.Headers.Set(proxy.SessionHeader, id)
+		}
 	})
 	c.OnError(func(response *colly.Response, err error) {
+		s.retryDue(ctx, r)
 		if err == nil {
 			return
 		}
-		s.logger.Error("can't visit article " + err.Error())
-		// fmt.Println(response.Request.Ctx)
+		s.onError(ctx, r, response, err)
+	})
+	c.OnResponse(func(response *colly.Response) {
+		s.retryDue(ctx, r)
+		metrics.PagesFetched.WithLabelValues(Source, strconv.Itoa(response.StatusCode)).Inc()
+		s.proxySwitcher.Succeeded(response.Request.ProxyURL, response.Request.URL.Hostname())
+		r.forgetAttempts(response.Request.URL.String())
+		// archived before the extraction, so that the pages it fails on can be reparsed
+		if response.StatusCode == http.StatusOK && !isListing(response.Request.URL) {
+			if id := s.archiveResponse(response); id != "" {
+				r.putArchiveID(response.Request.URL.String(), id)
+			}
+		}
+	})
+	c.OnScraped(func(response *colly.Response) {
+		// the pages that are not articles are not extracted
+		r.takeArchiveID(response.Request.URL.String())
+	})
+
+	return c, nil
+}
+
+// onArticle registers the extraction of the articles and their publishing.
+func (s *Scrapper) onArticle(ctx context.Context, c *colly.Collector, r *run) {
+	c.OnHTML("html", func(e *colly.HTMLElement) {
+		if isListing(e.Request.URL) {
+			return
+		}
+		archiveID := r.takeArchiveID(e.Request.URL.String())
+		a, err := extractArticle(e.Request.URL.String(), e.DOM)
+		if err != nil {
+			return
+		}
+		metrics.ArticlesExtracted.WithLabelValues(Source).Inc()
+		if s.quality != nil {
+			s.quality.Observe(Source, a.observation())
+		}
+		if _, ok := r.skip[a.url]; ok {
+			// published before the day was interrupted
+			return
+		}
 
-		if strings.Contains(err.Error(), "Too Many Requests") {
-			time.Sleep(10 * time.Second)
-			err = response.Request.Retry()
+		if selector, err := a.validate(); err != nil {
+			metrics.ExtractionFailures.WithLabelValues(Source, selector).Inc()
+			r.mu.Lock()
+			r.failed++
+			r.mu.Unlock()
+			s.logger.Error("incomplete article: "+err.Error(), slog.String("url", a.url))
+			s.pushDeadLetter(ctx, r, deadletter.Entry{
+				Url:      a.url,
+				Reason:   "incomplete article: " + err.Error(),
+				Class:    deadletter.Extraction,
+				Status:   e.Response.StatusCode,
+				Attempts: 1,
+			})
+			return
+		}
+
+		var revision int
+		if r.recheck {
+			var changed bool
+			revision, changed, err = s.revisions.Check(ctx, Source, a.url, a.hash())
 			if err != nil {
-				s.logger.Error("can't retry: " + err.Error())
+				s.logger.Error(err.Error(), slog.String("url", a.url))
+			}
+			if !changed {
 				return
 			}
-		} else if strings.Contains(err.Error(), "Bad Gateway") {
-			pr, err := url.Parse(response.Request.ProxyURL)
+		}
 
-			if err != nil {
-				s.logger.Error("bad proxy: " + err.Error())
-				return
+		err = s.sendMessage(ctx, r, a, archiveID, revision)
+		// reparses work offline, the live revisions are not changed by them
+		if err == nil && !r.recheck && !r.reparse && s.revisions != nil {
+			if err := s.revisions.Track(ctx, Source, a.url, a.hash(), a.published()); err != nil {
+				s.logger.Error(err.Error(), slog.String("url", a.url))
 			}
+		}
+		r.mu.Lock()
+		if err != nil {
+			r.failed++
+		} else {
+			r.published++
+			r.publishedURLs = append(r.publishedURLs, a.url)
+		}
+		r.mu.Unlock()
+		if err != nil {
+			s.logger.Error("ria sendMessage: " + err.Error())
+			return
+		}
+	})
+}
+
+func (s *Scrapper) onError(ctx context.Context, r *run, response *colly.Response, err error) {
+	metrics.PagesFetched.WithLabelValues(Source, strconv.Itoa(response.StatusCode)).Inc()
+	class := retry.Classify(response.StatusCode, err)
+	attempts := r.attempt(response.Request.URL.String())
+
+	s.logger.Error("can't visit article "+err.Error(),
+		slog.String("url", response.Request.URL.String()),
+		slog.String("class", class.String()),
+		slog.Int("status", response.StatusCode),
+		slog.Int("attempt", attempts))
+
+	switch class {
+	case retry.Canceled:
+		r.forgetAttempts(response.Request.URL.String())
+		r.addUnfinished(response.Request.URL.String())
+		return
+	case retry.Throttled:
+		s.proxySwitcher.Throttle(response.Request.ProxyURL,
+			response.Request.URL.Hostname(),
+			proxy.ParseRetryAfter(*response.Headers, s.config().DefaultRetryAfter))
+	case retry.Proxy:
+		// the proxy is not to blame when the whole domain fails, which is known for sure only
+		// when the other proxies get responses from it
+		host := response.Request.URL.Hostname()
+		if s.breaker.State(host) != breaker.Closed ||
+			!s.proxySwitcher.OthersSucceeded(response.Request.ProxyURL, host, s.config().ProxyBlameWindow) {
+			break
+		}
+		pr, err := url.Parse(response.Request.ProxyURL)
+		if err != nil {
+			s.logger.Error("bad proxy: " + err.Error())
+		} else {
 			s.proxySwitcher.GetCmdChan() <- proxy.CommandMessage{
 				Cmd: proxy.Delete, Url: pr,
 			}
+		}
+	}
 
+	if class.Retryable() && attempts < s.config().Retry.MaxAttempts {
+		// throttled requests go to another proxy at once, the others wait for backoff
+		// without holding the slot of the collector
+		if class != retry.Throttled {
+			s.scheduleRetry(r, response, class, attempts, err)
+			return
+		}
+		retryErr := response.Request.Retry()
+		if retryErr == nil {
+			return
 		}
+		s.logger.Error("can't retry: " + retryErr.Error())
+	}
+
+	s.fail(ctx, r, response, class, attempts, err)
+}
+
+// scheduleRetry queues the request to be retried after the backoff.
+func (s *Scrapper) scheduleRetry(r *run, response *colly.Response, class retry.Class, attempts int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retries = append(r.retries, pendingRetry{
+		response: response,
+		class:    class,
+		attempts: attempts,
+		err:      err,
+		at:       time.Now().Add(s.config().Retry.Backoff(attempts)),
 	})
-	c.OnResponse(func(response *colly.Response) {
-		if !strings.Contains(response.Request.URL.String(), "more.html") {
+}
+
+// retryDue sends the retries that are due. It is called from the callbacks of the collector
+// and by wait when nothing is in flight, so the collector is never waited for while a retry
+// is added to it. The retries left when the day is draining are unfinished.
+func (s *Scrapper) retryDue(ctx context.Context, r *run) {
+	stop := r.draining.Load() || ctx.Err() != nil
+	now := time.Now()
+	r.mu.Lock()
+	var due []pendingRetry
+	left := r.retries[:0]
+	for _, p := range r.retries {
+		if stop || !p.at.After(now) {
+			due = append(due, p)
+		} else {
+			left = append(left, p)
+		}
+	}
+	r.retries = left
+	r.mu.Unlock()
+
+	for _, p := range due {
+		u := p.response.Request.URL.String()
+		if stop {
+			r.forgetAttempts(u)
+			r.addUnfinished(u)
+			continue
+		}
+		if err := p.response.Request.Retry(); err != nil {
+			s.logger.Error("can't retry: " + err.Error())
+			s.fail(ctx, r, p.response, p.class, p.attempts, p.err)
+		}
+	}
+}
 
+// wait waits for the pages of the run, including the retries that are not due yet.
+func (s *Scrapper) wait(ctx context.Context, c *colly.Collector, r *run) {
+	for {
+		c.Wait()
+
+		r.mu.Lock()
+		var next time.Time
+		for _, p := range r.retries {
+			if next.IsZero() || p.at.Before(next) {
+				next = p.at
+			}
+		}
+		r.mu.Unlock()
+		if next.IsZero() {
+			return
 		}
-		// fmt.Println(response.Request.ProxyURL)
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-t.C:
+		case <-r.drained:
+		case <-ctx.Done():
+		}
+		t.Stop()
+		s.retryDue(ctx, r)
+	}
+}
+
+// fail counts the url as failed and pushes it to the dead letter queue.
+func (s *Scrapper) fail(ctx context.Context, r *run, response *colly.Response, class retry.Class, attempts int, err error) {
+	r.forgetAttempts(response.Request.URL.String())
+	r.mu.Lock()
+	r.failed++
+	r.mu.Unlock()
+
+	s.pushDeadLetter(ctx, r, deadletter.Entry{
+		Url:      response.Request.URL.String(),
+		Reason:   err.Error(),
+		Class:    class.String(),
+		Status:   response.StatusCode,
+		Attempts: attempts,
 	})
+}
 
-	date, err := time.Parse("20060102", day)
-	if err != nil {
-		s.logger.Error("bad date " + err.Error())
+func (s *Scrapper) pushDeadLetter(ctx context.Context, r *run, entry deadletter.Entry) {
+	// a failed recheck is claimed again later, it is not a missing article
+	if s.deadLetter == nil || r.recheck {
+		return
 	}
-	timeStart := time.Now()
-	s.logger.Info("start scrapping day", slog.Time("date", date))
-	if err := c.Visit("https://ria.ru/" + day + "/"); err != nil {
-		return fmt.Errorf("can't start scrapping: %w", err)
+	entry.Source = Source
+	entry.Day = r.day
+	entry.FailedAt = time.Now()
+	if err := s.deadLetter.Push(ctx, entry); err != nil {
+		s.logger.Error("can't push to dead letter queue: " + err.Error())
 	}
+}
 
-	c.Wait()
-
-	duration := time.Now().Sub(timeStart).String()
-	doneMessage, err := sonic.Marshal(model.DonePayload{
-		Date:     date.Format("2006-01-02T15:00:00"),
-		Count:    counter,
-		Duration: duration,
+// archiveResponse writes the raw page to the archive and returns its record id, "" if there is no archive.
+func (s *Scrapper) archiveResponse(response *colly.Response) string {
+	if s.archive == nil {
+		return ""
+	}
+	var header http.Header
+	if response.Headers != nil {
+		header = *response.Headers
+	}
+	var proxyHost string
+	if u, err := url.Parse(response.Request.ProxyURL); err == nil {
+		proxyHost = u.Host
+	}
+	id, err := s.archive.Write(archive.Response{
+		URL:       response.Request.URL.String(),
+		Status:    response.StatusCode,
+		Header:    header,
+		Body:      response.Body,
+		Proxy:     proxyHost,
+		FetchedAt: time.Now(),
 	})
+	if err != nil {
+		s.logger.Error("can't archive response: "+err.Error(), slog.String("url", response.Request.URL.String()))
+		return ""
+	}
+	return id
+}
 
+func (s *Scrapper) sendMessage(ctx context.Context, r *run, a article, archiveID string, revision int) error {
+	partition, err := getPartition(a.url, s.config().PartitionsCount)
 	if err != nil {
-		return fmt.Errorf("can't marshal done message: %w", err)
+		metrics.ArticlesFailed.WithLabelValues(Source, "unknown").Inc()
+		return fmt.Errorf("can't get partition: %w", err)
 	}
-	s.rdb.Publish(ctx, s.redisChanelName+"_day_done", doneMessage)
-	s.logger.Info("scraped",
-		slog.String("date", date.Format("02.01.2006")),
-		slog.Int("count", counter),
-		slog.String("duration", duration))
-	s.articlesDate.Clear()
-	s.articles.Clear()
 
+	if err := s.publish(ctx, r, a, archiveID, revision, partition); err != nil {
+		metrics.ArticlesFailed.WithLabelValues(Source, strconv.Itoa(partition)).Inc()
+		return err
+	}
+	metrics.ArticlesPublished.WithLabelValues(Source, strconv.Itoa(partition)).Inc()
 	return nil
 }
 
-func (s *Scrapper) sendMessage(ctx context.Context, url string) error {
-	partition, err := getPartition(url, s.partitionsCount)
-	if err != nil {
-		return fmt.Errorf("can't get partition: %w", err)
+func (s *Scrapper) publish(ctx context.Context, r *run, a article, archiveID string, revision, partition int) error {
+	redisChanel := r.channel + ":" + strconv.Itoa(partition)
+	raw, clean := a.texts()
+	payload := model.ScrapperPayload{
+		Url:       a.url,
+		Date:      a.date.Format("2006-01-02T15:00:00"),
+		Text:      clean,
+		RawText:   raw,
+		ArchiveID: archiveID,
 	}
-	redisChanel := s.redisChanelName + ":" + strconv.Itoa(partition)
-	date, ok := s.articlesDate.Load(url)
-	if !ok {
-		return fmt.Errorf("no date " + url)
+	switch {
+	case revision > 0:
+		payload.Event = model.ArticleUpdated
+		payload.Revision = revision
+	case r.reparse:
+		payload.Event = model.ArticleReparsed
 	}
-	text, ok := s.articles.Load(url)
-	if !ok {
-		return fmt.Errorf("no text " + url)
+	// reparses work offline, the live fingerprints are not changed by them
+	if s.dedup != nil && !r.reparse {
+		// the article is published without the cluster rather than not at all
+		match, err := s.dedup.Add(ctx, a.url, clean)
+		if err != nil {
+			s.logger.Error("can't find duplicates: "+err.Error(), slog.String("url", a.url))
+		} else {
+			payload.Fingerprint = strconv.FormatUint(match.Fingerprint, 16)
+			payload.ClusterID = match.Cluster
+			payload.DuplicateOf = match.DuplicateOf
+		}
 	}
-	redisMessage, err := sonic.Marshal(model.ScrapperPayload{
-		Url:  url,
-		Date: date.(time.Time).Format("2006-01-02T15:00:00"),
-		Text: strings.Join(text.([]string), " "),
-	})
+	redisMessage, err := sonic.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("can't marshal message: %w", err)
 	}
-	err = s.rdb.Publish(ctx, redisChanel, redisMessage).Err()
+	err = r.publisher.Publish(ctx, redisChanel, redisMessage)
 	if err != nil {
 		return fmt.Errorf("can't publish article: %w", err)
 	}
@@ -267,3 +845,10 @@ func getPartition(url string, partitionN int) (int, error) {
 	}
 	return int(h.Sum32()) % partitionN, nil
 }
+
+// isListing reports whether u is a day page or its pagination, not an article.
+func isListing(u *url.URL) bool {
+	return strings.HasSuffix(u.Path, "/") ||
+		strings.Contains(u.Path, "more.html") ||
+		strings.HasPrefix(u.Path, "/services/")
+}
@@ -0,0 +1,556 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/STTM-NSU/web-scrapper/internal/concurrency"
+	"github.com/STTM-NSU/web-scrapper/internal/lease"
+	"github.com/STTM-NSU/web-scrapper/internal/schedule"
+)
+
+const (
+	dayFormat      = "20060102"
+	leaseRetryWait = 30 * time.Second
+)
+
+type Scrapper interface {
+	Scrap(ctx context.Context, day string) error
+}
+
+// Checkpoints keeps the next day of the backfill and the scraped days between restarts.
+type Checkpoints interface {
+	Next(ctx context.Context, source string) (string, error)
+	SetNext(ctx context.Context, source, day string) error
+	MarkDone(ctx context.Context, source, day string) error
+	IsDone(ctx context.Context, source, day string) (bool, error)
+}
+
+type kind int
+
+const (
+	live kind = iota
+	backfill
+	queued
+)
+
+type Plan struct {
+	NextLivePoll   *time.Time `json:"next_live_poll,omitempty"`
+	Backfill       bool       `json:"backfill"`
+	BackfillChange *time.Time `json:"backfill_change,omitempty"`
+	NextReverify   *time.Time `json:"next_reverify,omitempty"`
+}
+
+type State struct {
+	Source  string   `json:"source"`
+	Paused  bool     `json:"paused"`
+	Leader  bool     `json:"leader"`
+	Live    string   `json:"live,omitempty"`
+	Current string   `json:"current,omitempty"`
+	Next    string   `json:"next"`
+	Queue   []string `json:"queue"`
+	Plan    Plan     `json:"plan"`
+}
+
+// Runner scrapes the days of a source in two loops. In the live one the leader of the source
+// polls today every live poll interval, in the other one queued days go first, then the backfill
+// walks from the start date to yesterday inside the backfill windows of the schedule.
+// Requests of the live loop go in the live lane of the concurrency controller, so they are not
+// delayed by the backfill. Every day is scraped under a lease, so the replicas share the backfill.
+type Runner struct {
+	source      string
+	scrapper    Scrapper
+	checkpoints Checkpoints
+	leases      *lease.Manager
+	election    *lease.Election
+	schedule    *schedule.Schedule
+	start       time.Time
+	logger      *slog.Logger
+	running     atomic.Bool
+
+	mu             sync.Mutex
+	paused         bool
+	backfillPaused bool
+	liveDay        string
+	current        string
+	next           time.Time
+	livePollAt     time.Time
+	queue          []string
+	wake           chan struct{}
+	liveWake       chan struct{}
+	pauseWake      chan struct{}
+}
+
+func New(source string,
+	scrapper Scrapper,
+	start time.Time,
+	checkpoints Checkpoints,
+	leases *lease.Manager,
+	sched *schedule.Schedule,
+	logger *slog.Logger) *Runner {
+	r := &Runner{
+		source:      source,
+		scrapper:    scrapper,
+		checkpoints: checkpoints,
+		leases:      leases,
+		schedule:    sched,
+		start:       start,
+		logger:      logger,
+		next:        start,
+		wake:        make(chan struct{}, 1),
+		liveWake:    make(chan struct{}, 1),
+		pauseWake:   make(chan struct{}, 1),
+	}
+	r.election = leases.Election(source+":live", func(bool) {
+		r.notify()
+	})
+	return r
+}
+
+func (r *Runner) Source() string {
+	return r.source
+}
+
+func (r *Runner) Leader() bool {
+	return r.election.Leader()
+}
+
+func (r *Runner) Run(ctx context.Context) {
+	r.running.Store(true)
+	defer r.running.Store(false)
+
+	elected := make(chan struct{})
+	go func() {
+		defer close(elected)
+		r.election.Run(ctx)
+	}()
+	defer func() { <-elected }()
+
+	r.restore(ctx)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			day, wait := r.takeLive()
+			if day == "" {
+				r.sleep(ctx, wait, r.liveWake)
+				continue
+			}
+			r.scrap(ctx, day, live)
+		}
+	}()
+
+	for ctx.Err() == nil {
+		day, k, wait := r.take()
+		if day == "" {
+			r.sleep(ctx, wait, r.wake)
+			// days left by dead replicas are picked up again
+			r.restore(ctx)
+			continue
+		}
+		r.scrap(ctx, day, k)
+	}
+	wg.Wait()
+}
+
+func (r *Runner) scrap(ctx context.Context, day string, k kind) {
+	if k == backfill {
+		done, err := r.checkpoints.IsDone(ctx, r.source, day)
+		if err != nil {
+			r.logger.Error(err.Error(), slog.String("source", r.source))
+			r.again(day, k)
+			r.sleep(ctx, leaseRetryWait, nil)
+			return
+		}
+		if done {
+			r.skip(day, k)
+			return
+		}
+	}
+
+	l, err := r.leases.TryAcquire(ctx, r.source+":"+day)
+	if err != nil || l == nil {
+		if err != nil {
+			r.logger.Error(err.Error(), slog.String("source", r.source))
+		} else {
+			r.logger.Debug("day is leased by another replica", slog.String("source", r.source), slog.String("day", day))
+		}
+		if !r.skip(day, k) {
+			r.sleep(ctx, leaseRetryWait, nil)
+		}
+		return
+	}
+	defer l.Release(context.WithoutCancel(ctx))
+
+	lane := concurrency.Backfill
+	if k == live {
+		lane = concurrency.Live
+	}
+	scrapCtx, stop := context.WithCancel(concurrency.WithLane(l.Context(), lane))
+	defer stop()
+	var stopped atomic.Bool
+	if k != live {
+		go r.watch(scrapCtx, day, func() {
+			stopped.Store(true)
+			stop()
+		})
+	}
+	err = r.scrapper.Scrap(scrapCtx, day)
+	if err != nil {
+		r.logger.Error("can't scrap "+r.source+" "+err.Error(), slog.String("day", day))
+	}
+	if ctx.Err() != nil {
+		// the day is interrupted, it is continued from its checkpoint after the restart
+		return
+	}
+	if l.Lost() {
+		// the day is continued from its checkpoint by the replica that takes the lease
+		r.skip(day, k)
+		return
+	}
+	if stopped.Load() {
+		// the day is drained and continued from its checkpoint when past days are allowed again
+		r.again(day, k)
+		return
+	}
+	if err != nil {
+		// the day is neither finished nor done, it is scraped again from its checkpoint
+		r.again(day, k)
+		r.sleep(ctx, leaseRetryWait, nil)
+		return
+	}
+
+	r.done(k)
+	if k != backfill {
+		return
+	}
+	if err := r.checkpoints.MarkDone(ctx, r.source, day); err != nil {
+		r.logger.Error(err.Error(), slog.String("source", r.source))
+		return
+	}
+	r.advance(ctx)
+}
+
+// watch calls stop when the source is paused or the backfill window of the schedule ends
+// while the past day is scraped, so that the live polls get the proxies.
+func (r *Runner) watch(ctx context.Context, day string, stop func()) {
+	for {
+		r.mu.Lock()
+		paused := r.paused
+		r.mu.Unlock()
+		allowed, change := r.schedule.Backfill(time.Now())
+		if paused || !allowed {
+			r.logger.Info("past day stopped",
+				slog.String("source", r.source),
+				slog.String("day", day),
+				slog.Bool("paused", paused))
+			stop()
+			return
+		}
+
+		var t *time.Timer
+		var at <-chan time.Time
+		if !change.IsZero() {
+			t = time.NewTimer(time.Until(change))
+			at = t.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-at:
+		case <-r.pauseWake:
+		}
+		if t != nil {
+			t.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// restore sets the backfill to the saved next day, the first one that is not scraped yet,
+// if it is after the start date.
+func (r *Runner) restore(ctx context.Context) {
+	day, err := r.checkpoints.Next(ctx, r.source)
+	if err != nil {
+		r.logger.Error(err.Error(), slog.String("source", r.source))
+		return
+	}
+	if day == "" {
+		return
+	}
+	next, err := time.Parse(dayFormat, day)
+	if err != nil {
+		r.logger.Error("bad saved next day "+err.Error(), slog.String("source", r.source))
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if next.Before(r.start) {
+		next = r.start
+	}
+	if next.Format(dayFormat) != r.next.Format(dayFormat) {
+		r.logger.Info("backfill continued from checkpoint", slog.String("source", r.source), slog.String("next", day))
+		r.next = next
+	}
+}
+
+// advance moves the saved next day past the days scraped by all the replicas.
+func (r *Runner) advance(ctx context.Context) {
+	day, err := r.checkpoints.Next(ctx, r.source)
+	if err != nil {
+		r.logger.Error(err.Error(), slog.String("source", r.source))
+		return
+	}
+	next := r.start
+	if day != "" {
+		if next, err = time.Parse(dayFormat, day); err != nil {
+			r.logger.Error("bad saved next day "+err.Error(), slog.String("source", r.source))
+			return
+		}
+	}
+
+	today := time.Now().Format(dayFormat)
+	for next.Format(dayFormat) < today {
+		done, err := r.checkpoints.IsDone(ctx, r.source, next.Format(dayFormat))
+		if err != nil {
+			r.logger.Error(err.Error(), slog.String("source", r.source))
+			return
+		}
+		if !done {
+			break
+		}
+		next = next.Add(24 * time.Hour)
+	}
+	if err := r.checkpoints.SetNext(ctx, r.source, next.Format(dayFormat)); err != nil {
+		r.logger.Error(err.Error(), slog.String("source", r.source))
+	}
+}
+
+// takeLive returns today if it is time to poll it, or how long to wait.
+func (r *Runner) takeLive() (string, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	switch {
+	case r.paused || !r.election.Leader():
+		// today is polled only by the leader
+		return "", r.schedule.LivePollInterval()
+	case now.Before(r.livePollAt):
+		return "", r.livePollAt.Sub(now)
+	}
+	r.liveDay = now.Format(dayFormat)
+	return r.liveDay, 0
+}
+
+// take returns the next past day to scrape and its kind, or how long to wait if there is nothing to scrape now.
+func (r *Runner) take() (string, kind, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	wait := r.schedule.LivePollInterval()
+	if r.paused {
+		return "", backfill, wait
+	}
+
+	allowed, change := r.schedule.Backfill(now)
+	if allowed != !r.backfillPaused {
+		r.backfillPaused = !allowed
+		r.logger.Info("backfill schedule changed",
+			slog.String("source", r.source),
+			slog.Bool("backfill", allowed),
+			slog.Time("until", change))
+	}
+	if !allowed {
+		if !change.IsZero() {
+			wait = min(wait, change.Sub(now))
+		}
+		return "", backfill, wait
+	}
+
+	if len(r.queue) > 0 {
+		r.current, r.queue = r.queue[0], r.queue[1:]
+		return r.current, queued, 0
+	}
+	if day := r.next.Format(dayFormat); day < now.Format(dayFormat) {
+		r.current = day
+		return r.current, backfill, 0
+	}
+	return "", backfill, wait
+}
+
+// skip leaves the day to another replica: a backfill day is passed, a queued day is queued again
+// and today is polled later. It returns false if the same day may come again right away.
+func (r *Runner) skip(day string, k kind) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clear(k)
+	switch k {
+	case queued:
+		r.queue = append(r.queue, day)
+		return false
+	case backfill:
+		r.next = r.next.Add(24 * time.Hour)
+		return true
+	default:
+		return false
+	}
+}
+
+// again leaves the day that was not finished to be taken first the next time.
+func (r *Runner) again(day string, k kind) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clear(k)
+	if k == queued {
+		r.queue = append([]string{day}, r.queue...)
+	}
+}
+
+// done finishes the current day: today is polled again after the interval, the past days are scraped once.
+func (r *Runner) done(k kind) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clear(k)
+	switch k {
+	case live:
+		r.livePollAt = time.Now().Add(r.schedule.LivePollInterval())
+	case backfill:
+		r.next = r.next.Add(24 * time.Hour)
+	}
+}
+
+func (r *Runner) clear(k kind) {
+	if k == live {
+		r.liveDay = ""
+	} else {
+		r.current = ""
+	}
+}
+
+func (r *Runner) sleep(ctx context.Context, d time.Duration, wake <-chan struct{}) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	case <-wake:
+	}
+}
+
+func (r *Runner) notify() {
+	for _, wake := range []chan struct{}{r.wake, r.liveWake} {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Running reports whether the loop of the runner is alive.
+func (r *Runner) Running() bool {
+	return r.running.Load()
+}
+
+// Busy reports whether a day is being scraped now.
+func (r *Runner) Busy() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current != "" || r.liveDay != ""
+}
+
+func (r *Runner) Pause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.paused = true
+	r.logger.Info("source paused", slog.String("source", r.source))
+	select {
+	case r.pauseWake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Runner) Resume() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.paused = false
+	r.logger.Info("source resumed", slog.String("source", r.source))
+	r.notify()
+}
+
+// Rescrape queues the days from from to to inclusive before the backfill, they are scraped in the backfill windows.
+func (r *Runner) Rescrape(from, to time.Time) error {
+	if to.Before(from) {
+		return fmt.Errorf("to=%s can't be before from=%s", to.Format(time.DateOnly), from.Format(time.DateOnly))
+	}
+	if to.After(time.Now()) {
+		return fmt.Errorf("to=%s can't be after now", to.Format(time.DateOnly))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var days int
+	for d := from; !d.After(to); d = d.Add(24 * time.Hour) {
+		r.queue = append(r.queue, d.Format(dayFormat))
+		days++
+	}
+	r.logger.Info("rescrape queued",
+		slog.String("source", r.source),
+		slog.String("from", from.Format(time.DateOnly)),
+		slog.String("to", to.Format(time.DateOnly)),
+		slog.Int("days", days))
+	r.notify()
+	return nil
+}
+
+func (r *Runner) State() State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return State{
+		Source:  r.source,
+		Paused:  r.paused,
+		Leader:  r.election.Leader(),
+		Live:    r.liveDay,
+		Current: r.current,
+		Next:    r.next.Format(dayFormat),
+		Queue:   append([]string{}, r.queue...),
+		Plan:    r.plan(),
+	}
+}
+
+func (r *Runner) plan() Plan {
+	now := time.Now()
+	var p Plan
+	if r.election.Leader() {
+		at := r.livePollAt
+		if at.Before(now) {
+			at = now
+		}
+		p.NextLivePoll = &at
+	}
+	var change time.Time
+	p.Backfill, change = r.schedule.Backfill(now)
+	if !change.IsZero() {
+		p.BackfillChange = &change
+	}
+	if at := r.schedule.NextReverify(now); !at.IsZero() && r.election.Leader() {
+		p.NextReverify = &at
+	}
+	return p
+}
@@ -0,0 +1,204 @@
+package schedule
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const DefaultLivePollInterval = 3600
+
+// Config is the schedule of a source. Windows are "HH:MM-HH:MM" in the time zone of the schedule,
+// a window may cross midnight. Past days, both backfill and queued ones, are scraped only inside
+// the backfill windows, all day if there are none, and never in the quiet hours.
+// Today is polled every LivePollInterval seconds regardless of the windows.
+// The past ReverifyDays days are queued for rescrape every day at ReverifyAt, 0 days turns it off.
+type Config struct {
+	Source           string   `yaml:"source"`
+	TimeZone         string   `yaml:"timeZone"`
+	LivePollInterval int      `yaml:"livePollInterval"`
+	BackfillWindows  []string `yaml:"backfillWindows"`
+	QuietHours       []string `yaml:"quietHours"`
+	ReverifyDays     int      `yaml:"reverifyDays"`
+	ReverifyAt       string   `yaml:"reverifyAt"`
+}
+
+func Default(source string) Config {
+	return Config{
+		Source:           source,
+		LivePollInterval: DefaultLivePollInterval,
+	}
+}
+
+// window is [from, to) as offsets from midnight, to is less than from if it crosses midnight.
+type window struct {
+	from time.Duration
+	to   time.Duration
+}
+
+func (w window) contains(d time.Duration) bool {
+	if w.from <= w.to {
+		return d >= w.from && d < w.to
+	}
+	return d >= w.from || d < w.to
+}
+
+type Schedule struct {
+	cfg        Config
+	loc        *time.Location
+	backfill   []window
+	quiet      []window
+	reverifyAt time.Duration
+}
+
+func New(cfg Config) (*Schedule, error) {
+	s := &Schedule{cfg: cfg, loc: time.Local}
+	if cfg.TimeZone != "" {
+		loc, err := time.LoadLocation(cfg.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("bad time zone %s: %w", cfg.TimeZone, err)
+		}
+		s.loc = loc
+	}
+
+	if cfg.LivePollInterval <= 0 {
+		return nil, fmt.Errorf("LivePollInterval=%d can't be <= 0", cfg.LivePollInterval)
+	}
+
+	var err error
+	if s.backfill, err = parseWindows(cfg.BackfillWindows); err != nil {
+		return nil, fmt.Errorf("bad backfill window: %w", err)
+	}
+	if s.quiet, err = parseWindows(cfg.QuietHours); err != nil {
+		return nil, fmt.Errorf("bad quiet hours: %w", err)
+	}
+
+	if cfg.ReverifyDays < 0 {
+		return nil, fmt.Errorf("ReverifyDays=%d can't be < 0", cfg.ReverifyDays)
+	}
+	if cfg.ReverifyDays > 0 {
+		if s.reverifyAt, err = parseClock(cfg.ReverifyAt); err != nil {
+			return nil, fmt.Errorf("bad ReverifyAt: %w", err)
+		}
+	}
+	return s, nil
+}
+
+func parseWindows(values []string) ([]window, error) {
+	res := make([]window, 0, len(values))
+	for _, v := range values {
+		from, to, ok := strings.Cut(v, "-")
+		if !ok {
+			return nil, fmt.Errorf("%s is not HH:MM-HH:MM", v)
+		}
+		var (
+			w   window
+			err error
+		)
+		if w.from, err = parseClock(from); err != nil {
+			return nil, err
+		}
+		if w.to, err = parseClock(to); err != nil {
+			return nil, err
+		}
+		if w.from == w.to {
+			return nil, fmt.Errorf("%s is empty", v)
+		}
+		res = append(res, w)
+	}
+	return res, nil
+}
+
+func parseClock(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(v))
+	if err != nil {
+		return 0, fmt.Errorf("%s is not HH:MM", v)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (s *Schedule) Source() string {
+	return s.cfg.Source
+}
+
+func (s *Schedule) LivePollInterval() time.Duration {
+	return time.Duration(s.cfg.LivePollInterval) * time.Second
+}
+
+func (s *Schedule) ReverifyDays() int {
+	return s.cfg.ReverifyDays
+}
+
+func (s *Schedule) midnight(t time.Time) time.Time {
+	t = t.In(s.loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.loc)
+}
+
+func (s *Schedule) backfillAllowed(t time.Time) bool {
+	d := t.Sub(s.midnight(t))
+	for _, w := range s.quiet {
+		if w.contains(d) {
+			return false
+		}
+	}
+	if len(s.backfill) == 0 {
+		return true
+	}
+	for _, w := range s.backfill {
+		if w.contains(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Backfill reports whether past days may be scraped at now and when that changes.
+// The change is the zero time if it never does.
+func (s *Schedule) Backfill(now time.Time) (bool, time.Time) {
+	allowed := s.backfillAllowed(now)
+	if len(s.backfill) == 0 && len(s.quiet) == 0 {
+		return allowed, time.Time{}
+	}
+
+	// the state can change only at the edges of the windows
+	for _, edge := range s.edges(now) {
+		if s.backfillAllowed(edge) != allowed {
+			return allowed, edge
+		}
+	}
+	return allowed, time.Time{}
+}
+
+// edges returns the starts and ends of all the windows after now within two days, in order.
+func (s *Schedule) edges(now time.Time) []time.Time {
+	var offsets []time.Duration
+	for _, w := range append(append([]window{}, s.backfill...), s.quiet...) {
+		offsets = append(offsets, w.from, w.to)
+	}
+
+	var res []time.Time
+	day := s.midnight(now)
+	for range 3 {
+		for _, off := range offsets {
+			if t := day.Add(off); t.After(now) {
+				res = append(res, t)
+			}
+		}
+		day = s.midnight(day.Add(36 * time.Hour))
+	}
+	slices.SortFunc(res, time.Time.Compare)
+	return res
+}
+
+// NextReverify returns the next time to queue the past days, the zero time if it is off.
+func (s *Schedule) NextReverify(now time.Time) time.Time {
+	if s.cfg.ReverifyDays == 0 {
+		return time.Time{}
+	}
+	next := s.midnight(now).Add(s.reverifyAt)
+	if !next.After(now) {
+		next = s.midnight(next.Add(36 * time.Hour)).Add(s.reverifyAt)
+	}
+	return next
+}
@@ -0,0 +1,130 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func at(day int, clock string) time.Time {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		panic(err)
+	}
+	return time.Date(2024, time.March, day, t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *Config)
+		wantErr bool
+	}{
+		{"default", func(cfg *Config) {}, false},
+		{"windows", func(cfg *Config) { cfg.BackfillWindows = []string{"22:00-06:00", " 12:00 - 13:30 "} }, false},
+		{"bad time zone", func(cfg *Config) { cfg.TimeZone = "Mars/Olympus" }, true},
+		{"no poll interval", func(cfg *Config) { cfg.LivePollInterval = 0 }, true},
+		{"no dash", func(cfg *Config) { cfg.BackfillWindows = []string{"22:00"} }, true},
+		{"bad clock", func(cfg *Config) { cfg.QuietHours = []string{"25:00-06:00"} }, true},
+		{"empty window", func(cfg *Config) { cfg.QuietHours = []string{"06:00-06:00"} }, true},
+		{"negative reverify days", func(cfg *Config) { cfg.ReverifyDays = -1 }, true},
+		{"reverify without time", func(cfg *Config) { cfg.ReverifyDays = 3 }, true},
+		{"reverify time is not checked when off", func(cfg *Config) { cfg.ReverifyAt = "soon" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default("rbc")
+			cfg.TimeZone = "UTC"
+			tt.modify(&cfg)
+			if _, err := New(cfg); (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	tests := []struct {
+		name        string
+		backfill    []string
+		quiet       []string
+		now         time.Time
+		wantAllowed bool
+		wantChange  time.Time
+	}{
+		{"no windows", nil, nil, at(10, "12:00"), true, time.Time{}},
+		{"inside", []string{"01:00-05:00"}, nil, at(10, "02:00"), true, at(10, "05:00")},
+		{"end is excluded", []string{"01:00-05:00"}, nil, at(10, "05:00"), false, at(11, "01:00")},
+		{"before", []string{"01:00-05:00"}, nil, at(10, "00:30"), false, at(10, "01:00")},
+		{"after midnight in a crossing window", []string{"22:00-06:00"}, nil, at(10, "03:00"), true, at(10, "06:00")},
+		{"before midnight in a crossing window", []string{"22:00-06:00"}, nil, at(10, "23:00"), true, at(11, "06:00")},
+		{"outside a crossing window", []string{"22:00-06:00"}, nil, at(10, "12:00"), false, at(10, "22:00")},
+		{"quiet hours only", nil, []string{"09:00-18:00"}, at(10, "10:00"), false, at(10, "18:00")},
+		{"quiet hours win", []string{"00:00-12:00"}, []string{"03:00-04:00"}, at(10, "03:30"), false, at(10, "04:00")},
+		{"until quiet hours", []string{"00:00-12:00"}, []string{"03:00-04:00"}, at(10, "01:00"), true, at(10, "03:00")},
+		{"adjacent windows", []string{"20:00-00:00", "00:00-04:00"}, nil, at(10, "21:00"), true, at(11, "04:00")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default("rbc")
+			cfg.TimeZone = "UTC"
+			cfg.BackfillWindows = tt.backfill
+			cfg.QuietHours = tt.quiet
+			s, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			allowed, change := s.Backfill(tt.now)
+			if allowed != tt.wantAllowed || !change.Equal(tt.wantChange) {
+				t.Errorf("Backfill(%s) = %t, %s, want %t, %s", tt.now, allowed, change, tt.wantAllowed, tt.wantChange)
+			}
+		})
+	}
+}
+
+func TestBackfillTimeZone(t *testing.T) {
+	cfg := Default("rbc")
+	cfg.TimeZone = "Europe/Moscow"
+	cfg.BackfillWindows = []string{"01:00-05:00"}
+	s, err := New(cfg)
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+
+	// 23:00 UTC is 02:00 in Moscow
+	allowed, change := s.Backfill(at(10, "23:00"))
+	if !allowed || !change.Equal(at(11, "02:00")) {
+		t.Errorf("Backfill() = %t, %s, want true, %s", allowed, change, at(11, "02:00"))
+	}
+}
+
+func TestNextReverify(t *testing.T) {
+	tests := []struct {
+		name string
+		days int
+		at   string
+		now  time.Time
+		want time.Time
+	}{
+		{"off", 0, "", at(10, "12:00"), time.Time{}},
+		{"later today", 3, "04:00", at(10, "01:00"), at(10, "04:00")},
+		{"now", 3, "04:00", at(10, "04:00"), at(11, "04:00")},
+		{"tomorrow", 3, "04:00", at(10, "12:00"), at(11, "04:00")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default("rbc")
+			cfg.TimeZone = "UTC"
+			cfg.ReverifyDays = tt.days
+			cfg.ReverifyAt = tt.at
+			s, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := s.NextReverify(tt.now); !got.Equal(tt.want) {
+				t.Errorf("NextReverify(%s) = %s, want %s", tt.now, got, tt.want)
+			}
+		})
+	}
+}
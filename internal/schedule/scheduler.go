@@ -0,0 +1,60 @@
+package schedule
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Runner is the part of the source runner the scheduler feeds.
+type Runner interface {
+	Leader() bool
+	Rescrape(from, to time.Time) error
+}
+
+// Scheduler queues the nightly re-verification of the past days of the source. Only the leader
+// of the source queues them, so the replicas don't scrape the days again one after another.
+type Scheduler struct {
+	schedule *Schedule
+	runner   Runner
+	logger   *slog.Logger
+}
+
+func NewScheduler(schedule *Schedule, runner Runner, logger *slog.Logger) *Scheduler {
+	return &Scheduler{
+		schedule: schedule,
+		runner:   runner,
+		logger:   logger,
+	}
+}
+
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		next := s.schedule.NextReverify(time.Now())
+		if next.IsZero() {
+			return
+		}
+		s.logger.Info("re-verification planned",
+			slog.String("source", s.schedule.Source()),
+			slog.Time("at", next),
+			slog.Int("days", s.schedule.ReverifyDays()))
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		if !s.runner.Leader() {
+			s.logger.Debug("re-verification is left to the leader", slog.String("source", s.schedule.Source()))
+			continue
+		}
+		to := time.Now().Add(-24 * time.Hour)
+		from := to.Add(-time.Duration(s.schedule.ReverifyDays()-1) * 24 * time.Hour)
+		if err := s.runner.Rescrape(from, to); err != nil {
+			s.logger.Error("can't queue re-verification "+err.Error(), slog.String("source", s.schedule.Source()))
+		}
+	}
+}
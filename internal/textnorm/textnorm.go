@@ -0,0 +1,73 @@
+package textnorm
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/text/unicode/norm"
+)
+
+var replacer = strings.NewReplacer(
+	// quotes
+	"«", `"`, "»", `"`, "„", `"`, "“", `"`, "”", `"`, "‟", `"`,
+	"‘", "'", "’", "'", "‚", "'", "‛", "'",
+	// dashes and the minus
+	"‐", "-", "‑", "-", "‒", "-", "–", "-", "—", "-", "―", "-", "−", "-",
+	"…", "...",
+	// invisible characters
+	"\u00ad", "", "\u200b", "", "\u200c", "", "\u200d", "", "\u2060", "", "\ufeff", "",
+)
+
+// Normalize returns s in NFC with the typographic quotes and dashes replaced by the ASCII ones
+// and every run of whitespace, the non-breaking spaces too, collapsed to a single space.
+func Normalize(s string) string {
+	s = replacer.Replace(norm.NFC.String(s))
+	return strings.Join(strings.FieldsFunc(s, unicode.IsSpace), " ")
+}
+
+// Cleaner removes the boilerplate of a source, the elements matching the selectors
+// and the text matching the patterns, from the text blocks of the articles.
+type Cleaner struct {
+	selectors string
+	patterns  []*regexp.Regexp
+}
+
+// NewCleaner creates the cleaner, the patterns are matched against the normalized text.
+func NewCleaner(selectors []string, patterns []*regexp.Regexp) *Cleaner {
+	return &Cleaner{
+		selectors: strings.Join(selectors, ", "),
+		patterns:  patterns,
+	}
+}
+
+// Block returns the text of the block without the boilerplate elements.
+func (c *Cleaner) Block(s *goquery.Selection) string {
+	if c.selectors == "" {
+		return s.Text()
+	}
+	clone := s.Clone()
+	clone.Find(c.selectors).Remove()
+	return clone.Text()
+}
+
+// Paragraph returns the normalized text without the boilerplate patterns, "" if nothing is left.
+func (c *Cleaner) Paragraph(s string) string {
+	s = Normalize(s)
+	for _, re := range c.patterns {
+		s = re.ReplaceAllString(s, "")
+	}
+	return strings.TrimSpace(s)
+}
+
+// Join returns the cleaned paragraphs one per line, the empty ones are dropped.
+func (c *Cleaner) Join(paragraphs []string) string {
+	res := make([]string, 0, len(paragraphs))
+	for _, p := range paragraphs {
+		if p = c.Paragraph(p); p != "" {
+			res = append(res, p)
+		}
+	}
+	return strings.Join(res, "\n")
+}